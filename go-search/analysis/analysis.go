@@ -0,0 +1,74 @@
+// Package analysis turns prose, such as doc comments, into index terms. Text
+// is split into words on anything that isn't a letter or digit, lower cased,
+// stripped of stop words and stemmed, so "Parsing the input," and "parses
+// input" share the terms "pars" and "input".
+package analysis
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Config selects the steps of the pipeline. It is stored in the index so the
+// server analyzes queries the same way the parser analyzed comments.
+type Config struct {
+	StopWords bool //drop common English words
+	Stem      bool //reduce words to their Porter stem
+	MinLength int  //drop words with fewer runes than this
+}
+
+// DefaultConfig is what the parser uses unless told otherwise
+var DefaultConfig = Config{StopWords: true, Stem: true, MinLength: 2}
+
+// An Analyzer runs text through the pipeline described by its Config
+type Analyzer struct {
+	Config
+}
+
+func New(c Config) *Analyzer {
+	return &Analyzer{c}
+}
+
+// Analyze returns the terms of text in the order they occur
+func (a *Analyzer) Analyze(text string) []string {
+	var terms []string
+	for _, w := range strings.FieldsFunc(text, isSeparator) {
+		if t := a.term(w); t != "" {
+			terms = append(terms, t)
+		}
+	}
+	return terms
+}
+
+// Term analyzes a single query word. It returns "" if the word is dropped by
+// the pipeline or isn't a single word.
+func (a *Analyzer) Term(word string) string {
+	if strings.IndexFunc(word, isSeparator) >= 0 {
+		return ""
+	}
+	return a.term(word)
+}
+
+// Drops reports whether the pipeline drops a single word entirely, as it does
+// stop words and words that are too short
+func (a *Analyzer) Drops(word string) bool {
+	return strings.IndexFunc(word, isSeparator) < 0 && a.term(word) == ""
+}
+
+func (a *Analyzer) term(w string) string {
+	w = strings.ToLower(w)
+	if len([]rune(w)) < a.MinLength {
+		return ""
+	}
+	if a.StopWords && stopWords[w] {
+		return ""
+	}
+	if a.Stem {
+		w = Stem(w)
+	}
+	return w
+}
+
+func isSeparator(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+}
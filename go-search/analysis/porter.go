@@ -0,0 +1,231 @@
+package analysis
+
+// Stem reduces a lower case English word to its stem using the Porter
+// stemming algorithm, as described in M.F. Porter, "An algorithm for suffix
+// stripping", 1980. Words of two letters or fewer and words with anything
+// other than the letters a to z are returned as they are.
+func Stem(word string) string {
+	if len(word) <= 2 {
+		return word
+	}
+	for i := 0; i < len(word); i++ {
+		if word[i] < 'a' || word[i] > 'z' {
+			return word
+		}
+	}
+	s := &stemmer{b: []byte(word)}
+	s.step1a()
+	s.step1b()
+	s.step1c()
+	s.step2()
+	s.step3()
+	s.step4()
+	s.step5()
+	return string(s.b)
+}
+
+type stemmer struct {
+	b []byte
+	j int //end of the stem once a suffix has matched
+}
+
+// cons reports whether b[i] is a consonant
+func (s *stemmer) cons(i int) bool {
+	switch s.b[i] {
+	case 'a', 'e', 'i', 'o', 'u':
+		return false
+	case 'y':
+		return i == 0 || !s.cons(i-1)
+	}
+	return true
+}
+
+// m measures the number of consonant sequences in b[:s.j+1]. Writing c for a
+// run of consonants and v for a run of vowels, every word is [c](vc){m}[v].
+func (s *stemmer) m() int {
+	n, i := 0, 0
+	for ; i <= s.j && s.cons(i); i++ {
+	}
+	for i <= s.j {
+		for ; i <= s.j && !s.cons(i); i++ {
+		}
+		if i > s.j {
+			break
+		}
+		for ; i <= s.j && s.cons(i); i++ {
+		}
+		n++
+	}
+	return n
+}
+
+// vowelInStem reports whether b[:s.j+1] contains a vowel
+func (s *stemmer) vowelInStem() bool {
+	for i := 0; i <= s.j; i++ {
+		if !s.cons(i) {
+			return true
+		}
+	}
+	return false
+}
+
+// doublec reports whether b[j-1:j+1] is a double consonant
+func (s *stemmer) doublec(j int) bool {
+	return j >= 1 && s.b[j] == s.b[j-1] && s.cons(j)
+}
+
+// cvc reports whether b[i-2:i+1] is consonant, vowel, consonant and the
+// last consonant isn't w, x or y. This is the condition for restoring an e,
+// as in cav(e), lov(e), hop(e).
+func (s *stemmer) cvc(i int) bool {
+	if i < 2 || !s.cons(i) || s.cons(i-1) || !s.cons(i-2) {
+		return false
+	}
+	switch s.b[i] {
+	case 'w', 'x', 'y':
+		return false
+	}
+	return true
+}
+
+// ends reports whether the word ends in suffix, setting j to the end of the
+// stem before it if so
+func (s *stemmer) ends(suffix string) bool {
+	n := len(suffix)
+	if n > len(s.b) || string(s.b[len(s.b)-n:]) != suffix {
+		return false
+	}
+	s.j = len(s.b) - n - 1
+	return true
+}
+
+// setto replaces the suffix after j with r
+func (s *stemmer) setto(r string) {
+	s.b = append(s.b[:s.j+1], r...)
+}
+
+// r replaces the suffix after j with r if the stem has a measure over 0
+func (s *stemmer) r(r string) {
+	if s.m() > 0 {
+		s.setto(r)
+	}
+}
+
+// replace tries each suffix in order, replacing the first that matches with
+// its replacement if the stem has a measure over 0
+func (s *stemmer) replace(pairs [][2]string) {
+	for _, p := range pairs {
+		if s.ends(p[0]) {
+			s.r(p[1])
+			return
+		}
+	}
+}
+
+// step1a removes plurals: caresses -> caress, ponies -> poni, cats -> cat
+func (s *stemmer) step1a() {
+	switch {
+	case s.ends("sses"):
+		s.b = s.b[:len(s.b)-2]
+	case s.ends("ies"):
+		s.setto("i")
+	case s.ends("ss"):
+	case s.ends("s"):
+		s.b = s.b[:len(s.b)-1]
+	}
+}
+
+// step1b removes -ed and -ing: agreed -> agree, plastered -> plaster,
+// hopping -> hop, filing -> file
+func (s *stemmer) step1b() {
+	if s.ends("eed") {
+		if s.m() > 0 {
+			s.b = s.b[:len(s.b)-1]
+		}
+		return
+	}
+	if !(s.ends("ed") || s.ends("ing")) || !s.vowelInStem() {
+		return
+	}
+	s.b = s.b[:s.j+1]
+	switch {
+	case s.ends("at"):
+		s.setto("ate")
+	case s.ends("bl"):
+		s.setto("ble")
+	case s.ends("iz"):
+		s.setto("ize")
+	case s.doublec(len(s.b) - 1):
+		switch s.b[len(s.b)-1] {
+		case 'l', 's', 'z':
+		default:
+			s.b = s.b[:len(s.b)-1]
+		}
+	default:
+		s.j = len(s.b) - 1
+		if s.m() == 1 && s.cvc(len(s.b)-1) {
+			s.b = append(s.b, 'e')
+		}
+	}
+}
+
+// step1c turns a terminal y into i when there is another vowel in the stem
+func (s *stemmer) step1c() {
+	if s.ends("y") && s.vowelInStem() {
+		s.b[len(s.b)-1] = 'i'
+	}
+}
+
+// step2 maps double suffixes to single ones: -ization -> -ize
+func (s *stemmer) step2() {
+	s.replace([][2]string{
+		{"ational", "ate"}, {"tional", "tion"}, {"enci", "ence"}, {"anci", "ance"},
+		{"izer", "ize"}, {"bli", "ble"}, {"alli", "al"}, {"entli", "ent"},
+		{"eli", "e"}, {"ousli", "ous"}, {"ization", "ize"}, {"ation", "ate"},
+		{"ator", "ate"}, {"alism", "al"}, {"iveness", "ive"}, {"fulness", "ful"},
+		{"ousness", "ous"}, {"aliti", "al"}, {"iviti", "ive"}, {"biliti", "ble"},
+		{"logi", "log"},
+	})
+}
+
+// step3 deals with -ic-, -full, -ness etc.
+func (s *stemmer) step3() {
+	s.replace([][2]string{
+		{"icate", "ic"}, {"ative", ""}, {"alize", "al"}, {"iciti", "ic"},
+		{"ical", "ic"}, {"ful", ""}, {"ness", ""},
+	})
+}
+
+// step4 removes -ant, -ence etc. from stems with a measure over 1
+func (s *stemmer) step4() {
+	for _, suffix := range []string{
+		"al", "ance", "ence", "er", "ic", "able", "ible", "ant", "ement",
+		"ment", "ent", "ion", "ou", "ism", "ate", "iti", "ous", "ive", "ize",
+	} {
+		if !s.ends(suffix) {
+			continue
+		}
+		if suffix == "ion" && (s.j < 0 || (s.b[s.j] != 's' && s.b[s.j] != 't')) {
+			return
+		}
+		if s.m() > 1 {
+			s.b = s.b[:s.j+1]
+		}
+		return
+	}
+}
+
+// step5 removes a final -e and reduces a final -ll when the measure is over 1
+func (s *stemmer) step5() {
+	s.j = len(s.b) - 1
+	if s.b[s.j] == 'e' {
+		s.j--
+		if m := s.m(); m > 1 || (m == 1 && !s.cvc(s.j)) {
+			s.b = s.b[:len(s.b)-1]
+		}
+	}
+	s.j = len(s.b) - 1
+	if s.b[s.j] == 'l' && s.doublec(s.j) && s.m() > 1 {
+		s.b = s.b[:len(s.b)-1]
+	}
+}
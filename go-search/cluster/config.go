@@ -0,0 +1,67 @@
+// Package cluster serves a corpus that has been split into shards, each held
+// by a shard server, through a coordinator that fans every query out to the
+// shards and merges their results. Which shards make up the cluster is read
+// from a JSON config file, e.g.
+//
+//	{"Shards": [
+//	  {"Name": "s0", "Addr": "localhost:8001", "Index": "./parser/index.0.gob"},
+//	  {"Name": "s1", "Addr": "localhost:8002", "Index": "./parser/index.1.gob"}
+//	]}
+//
+// where the index files are written by the parser with -shards.
+package cluster
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// A Shard is one server holding part of the corpus
+type Shard struct {
+	Name  string
+	Addr  string //host:port the shard server listens on
+	Index string //index file the shard server loads
+}
+
+// A Config lists the shards of a cluster
+type Config struct {
+	Shards []Shard
+}
+
+// LoadConfig reads a cluster config file
+func LoadConfig(file string) (*Config, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	c := &Config{}
+	if err := json.NewDecoder(f).Decode(c); err != nil {
+		return nil, fmt.Errorf("cluster: reading %v: %v", file, err)
+	}
+	if len(c.Shards) == 0 {
+		return nil, fmt.Errorf("cluster: %v lists no shards", file)
+	}
+	names := make(map[string]bool)
+	for _, s := range c.Shards {
+		if s.Name == "" || s.Addr == "" || s.Index == "" {
+			return nil, fmt.Errorf("cluster: shard %+v needs a Name, Addr and Index", s)
+		}
+		if names[s.Name] {
+			return nil, fmt.Errorf("cluster: shard %q is listed twice", s.Name)
+		}
+		names[s.Name] = true
+	}
+	return c, nil
+}
+
+// Shard returns the shard called name
+func (c *Config) Shard(name string) (Shard, bool) {
+	for _, s := range c.Shards {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	return Shard{}, false
+}
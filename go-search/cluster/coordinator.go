@@ -0,0 +1,156 @@
+package cluster
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"go-search/search"
+)
+
+// Paths of the endpoints shard servers answer the coordinator on
+const (
+	StatsPath = "/shard/stats"
+	TopPath   = "/shard/top"
+)
+
+// A StatsRequest asks a shard for the statistics of a query's terms
+type StatsRequest struct {
+	Query   string
+	Options search.Options
+}
+
+// A TopRequest asks a shard for its best K results for a query, scored with
+// the global statistics in Options.Global
+type TopRequest struct {
+	Query   string
+	Options search.Options
+	K       int
+}
+
+// A ShardError is a shard that couldn't be reached or failed to answer
+type ShardError struct {
+	Shard string
+	Err   error
+}
+
+func (e *ShardError) Error() string {
+	return fmt.Sprintf("shard %v: %v", e.Shard, e.Err)
+}
+
+// A Coordinator answers queries by fanning them out to every shard
+type Coordinator struct {
+	shards []Shard
+	client *http.Client
+}
+
+func NewCoordinator(c *Config) *Coordinator {
+	return &Coordinator{shards: c.Shards, client: &http.Client{Timeout: 30 * time.Second}}
+}
+
+// Search runs a query across every shard and returns the page of the merged
+// results selected by opts, as search.Run does for a single index. Terms are
+// scored with document frequencies summed over all shards. A query a shard
+// rejects is returned as a plain error, any other failure as a *ShardError.
+func (co *Coordinator) Search(query string, opts search.Options) (search.Page, error) {
+	t0 := time.Now()
+	offset, limit, err := search.Bounds(query, opts)
+	if err != nil {
+		return search.Page{}, err
+	}
+
+	stats := make([]search.ShardStats, len(co.shards))
+	err = co.each(func(i int, s Shard) error {
+		return co.post(s, StatsPath, StatsRequest{query, opts}, &stats[i])
+	})
+	if err != nil {
+		return search.Page{}, err
+	}
+	global := &search.ShardStats{}
+	for _, st := range stats {
+		global.Add(st)
+	}
+	opts.Global = global
+
+	//one result past the end of the page tells whether there is another page
+	k := offset + limit + 1
+	tops := make([]search.Page, len(co.shards))
+	err = co.each(func(i int, s Shard) error {
+		return co.post(s, TopPath, TopRequest{query, opts, k}, &tops[i])
+	})
+	if err != nil {
+		return search.Page{}, err
+	}
+
+	var results search.Results
+	var modules [][]search.ModuleCount
+	total, didYouMean := 0, ""
+	for _, top := range tops {
+		results = append(results, top.Results...)
+		modules = append(modules, top.Modules)
+		total += top.Total
+		if didYouMean == "" {
+			didYouMean = top.DidYouMean
+		}
+	}
+	sort.Stable(sort.Reverse(results))
+	if len(results) > k {
+		results = results[:k]
+	}
+
+	opts.Global = nil
+	page, err := search.Paginate(results, total, query, opts)
+	page.DidYouMean = didYouMean
+	page.Modules = search.SumModules(modules...)
+	page.Took = time.Since(t0)
+	return page, err
+}
+
+// each calls f for every shard concurrently, returning the first error
+func (co *Coordinator) each(f func(i int, s Shard) error) error {
+	errc := make(chan error, len(co.shards))
+	for i, s := range co.shards {
+		go func(i int, s Shard) {
+			errc <- f(i, s)
+		}(i, s)
+	}
+	var first error
+	for range co.shards {
+		if err := <-errc; err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// post sends req to path on shard s as JSON and decodes the reply into res
+func (co *Coordinator) post(s Shard, path string, req, res interface{}) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	resp, err := co.client.Post("http://"+s.Addr+path, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return &ShardError{s.Name, err}
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusBadRequest:
+		//every shard parses the query the same way, so the query is at fault
+		msg, _ := io.ReadAll(resp.Body)
+		return errors.New(strings.TrimSpace(string(msg)))
+	default:
+		return &ShardError{s.Name, errors.New(resp.Status)}
+	}
+	if err := json.NewDecoder(resp.Body).Decode(res); err != nil {
+		return &ShardError{s.Name, err}
+	}
+	return nil
+}
@@ -0,0 +1,179 @@
+// Package importpath maps the dirs the parser indexes onto the import paths
+// their packages are stored under. A dir in a module is imported by the
+// module's path joined with its path below the module root, one in the src
+// dir of a GOPATH entry by its path below src. Module archives are laid out
+// either like the module proxy's zips, module@version/..., or as a tree with
+// go.mod files of its own.
+package importpath
+
+import (
+	"go/build"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// A Module is a Go module the indexed dirs belong to
+type Module struct {
+	Path    string
+	Version string //only known for dirs in the module cache, e.g. mod@v1.2.3
+	Root    string //dir holding the go.mod
+}
+
+var (
+	modMu sync.Mutex
+	//map dirs to the module of their nearest go.mod, nil if there is none
+	modCache = make(map[string]*Module)
+)
+
+// Find returns the module of the nearest go.mod at or above dir
+func Find(dir string) *Module {
+	modMu.Lock()
+	m, ok := modCache[dir]
+	modMu.Unlock()
+	if ok {
+		return m
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "go.mod")); err == nil {
+		if path := ModulePath(data); path != "" {
+			m = &Module{Path: path, Root: dir}
+			if i := strings.LastIndex(filepath.Base(dir), "@"); i >= 0 {
+				m.Version = filepath.Base(dir)[i+1:]
+			}
+		}
+	} else if parent := filepath.Dir(dir); parent != dir {
+		m = Find(parent)
+	}
+	modMu.Lock()
+	modCache[dir] = m
+	modMu.Unlock()
+	return m
+}
+
+// ModulePath returns the path given by the module directive of a go.mod file
+func ModulePath(gomod []byte) string {
+	for _, line := range strings.Split(string(gomod), "\n") {
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 || fields[0] != "module" {
+			continue
+		}
+		if path, err := strconv.Unquote(fields[1]); err == nil {
+			return path
+		}
+		return fields[1]
+	}
+	return ""
+}
+
+// Dir maps a directory onto the import path its postings are stored under:
+// its path within the module of the nearest go.mod, or else within the src
+// dir of a GOPATH entry. Dirs outside of both are stored under their path
+// from the parent of root, the dir they were walked from, so root's own name
+// is kept. Only one version of each module can be indexed at a time, as they
+// share import paths.
+func Dir(dir, root string) (string, *Module) {
+	absPath, _ := filepath.Abs(dir)
+	absRoot, _ := filepath.Abs(root)
+	path := absPath
+	if rel, err := filepath.Rel(filepath.Dir(absRoot), absPath); err == nil {
+		path = filepath.ToSlash(rel)
+	}
+	m := Find(absPath)
+	if m != nil {
+		rel, _ := filepath.Rel(m.Root, absPath)
+		path = InModule(m, filepath.ToSlash(rel))
+	} else {
+		for _, gopath := range filepath.SplitList(build.Default.GOPATH) {
+			src := filepath.Join(gopath, "src") + string(filepath.Separator)
+			if strings.HasPrefix(absPath, src) {
+				path = filepath.ToSlash(strings.TrimPrefix(absPath, src))
+				break
+			}
+		}
+	}
+	return path, m
+}
+
+// InModule is the import path of the package in dir rel below the root of
+// module m. The standard library's go.mod in GOROOT/src declares module std,
+// but its packages are imported by their path below src, as io or net/http.
+func InModule(m *Module, rel string) string {
+	if m.Path == "std" {
+		return rel
+	}
+	if rel == "." || rel == "" {
+		return m.Path
+	}
+	return m.Path + "/" + rel
+}
+
+// ArchiveDir maps a dir within an archive onto its import path and module,
+// given the contents of the archive's go.mod files by their dir. Dirs outside
+// of any module are stored under their path in the archive, with a nil module.
+func ArchiveDir(dir string, gomods map[string][]byte) (string, *Module) {
+	m := archiveModule(dir, gomods)
+	if m == nil {
+		return dir, nil
+	}
+	return InModule(m, strings.TrimPrefix(strings.TrimPrefix(dir, m.Root), "/")), m
+}
+
+// archiveModule returns the module dir belongs to within an archive, or nil
+// if it has none. Root is the module's dir in the archive.
+func archiveModule(dir string, gomods map[string][]byte) *Module {
+	//the proxy lays zips out as module@version/..., with upper case escaped
+	if i := strings.Index(dir, "@"); i >= 0 {
+		root := dir
+		if j := strings.Index(dir[i:], "/"); j >= 0 {
+			root = dir[:i+j]
+		}
+		return &Module{Path: unescape(dir[:i]), Version: root[i+1:], Root: root}
+	}
+	for d := dir; ; d = path.Dir(d) {
+		if gomod, ok := gomods[d]; ok {
+			if p := ModulePath(gomod); p != "" {
+				return &Module{Path: p, Root: d}
+			}
+		}
+		if d == "." || d == "/" {
+			return nil
+		}
+	}
+}
+
+// unescape undoes the module proxy's escaping of upper case letters in
+// module paths, e.g. github.com/!azure becomes github.com/Azure
+func unescape(p string) string {
+	var b strings.Builder
+	upper := false
+	for _, r := range p {
+		switch {
+		case r == '!':
+			upper = true
+		case upper:
+			b.WriteString(strings.ToUpper(string(r)))
+			upper = false
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// Vendored reports whether the slash separated dir is within a vendor dir.
+// Vendored copies would be stored under their upstream import path, so
+// different copies of a package would collide; they aren't indexed.
+func Vendored(dir string) bool {
+	for _, elem := range strings.Split(dir, "/") {
+		if elem == "vendor" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,129 @@
+package importpath
+
+import (
+	"go/build"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDirStd(t *testing.T) {
+	src := filepath.Join(build.Default.GOROOT, "src")
+	tests := []struct {
+		dir, want string
+	}{
+		{"io", "io"},
+		{"net/http", "net/http"},
+		{"cmd/go", "cmd/go"},
+	}
+	for _, tt := range tests {
+		if got, _ := Dir(filepath.Join(src, tt.dir), src); got != tt.want {
+			t.Errorf("Dir(%q) = %q, want %q", tt.dir, got, tt.want)
+		}
+	}
+}
+
+func TestDir(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "corp")
+	files := map[string]string{
+		"m/go.mod":        "module example.com/m // the module\n",
+		"m/sub/go.mod":    "module \"example.com/sub\"\n",
+		"plain/util/u.go": "package util\n",
+	}
+	for name, data := range files {
+		name = filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, dir := range []string{"m/a/b", "m/sub/c"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		dir, want, mod string
+	}{
+		{"m", "example.com/m", "example.com/m"},
+		{"m/a/b", "example.com/m/a/b", "example.com/m"},
+		{"m/sub", "example.com/sub", "example.com/sub"},
+		{"m/sub/c", "example.com/sub/c", "example.com/sub"},
+		//outside any module, by the path from the root's parent
+		{"plain/util", "corp/plain/util", ""},
+		{".", "corp", ""},
+	}
+	for _, tt := range tests {
+		got, m := Dir(filepath.Join(root, tt.dir), root)
+		mod := ""
+		if m != nil {
+			mod = m.Path
+		}
+		if got != tt.want || mod != tt.mod {
+			t.Errorf("Dir(%q) = %q, %q, want %q, %q", tt.dir, got, mod, tt.want, tt.mod)
+		}
+	}
+}
+
+func TestInModule(t *testing.T) {
+	tests := []struct {
+		mod, rel, want string
+	}{
+		{"std", "io", "io"},
+		{"std", "net/http", "net/http"},
+		{"github.com/gorilla/mux", ".", "github.com/gorilla/mux"},
+		{"github.com/gorilla/mux", "internal/x", "github.com/gorilla/mux/internal/x"},
+	}
+	for _, tt := range tests {
+		if got := InModule(&Module{Path: tt.mod}, tt.rel); got != tt.want {
+			t.Errorf("InModule(%q, %q) = %q, want %q", tt.mod, tt.rel, got, tt.want)
+		}
+	}
+}
+
+func TestArchiveDir(t *testing.T) {
+	gomods := map[string][]byte{
+		"repo":     []byte("module example.com/repo\n"),
+		"repo/sub": []byte("module example.com/sub\n"),
+	}
+	tests := []struct {
+		dir, want, version string
+	}{
+		{"github.com/!azure/sdk@v1.2.3", "github.com/Azure/sdk", "v1.2.3"},
+		{"github.com/!azure/sdk@v1.2.3/blob", "github.com/Azure/sdk/blob", "v1.2.3"},
+		{"repo", "example.com/repo", ""},
+		{"repo/x/y", "example.com/repo/x/y", ""},
+		{"repo/sub/z", "example.com/sub/z", ""},
+		{"loose/pkg", "loose/pkg", ""},
+	}
+	for _, tt := range tests {
+		got, m := ArchiveDir(tt.dir, gomods)
+		version := ""
+		if m != nil {
+			version = m.Version
+		}
+		if got != tt.want || version != tt.version {
+			t.Errorf("ArchiveDir(%q) = %q, %q, want %q, %q", tt.dir, got, version, tt.want, tt.version)
+		}
+	}
+}
+
+func TestVendored(t *testing.T) {
+	tests := []struct {
+		dir  string
+		want bool
+	}{
+		{"vendor/example.com/lib", true},
+		{"m1/vendor/example.com/lib", true},
+		{"m1/vendors/lib", false},
+		{"example.com/lib", false},
+	}
+	for _, tt := range tests {
+		if got := Vendored(tt.dir); got != tt.want {
+			t.Errorf("Vendored(%q) = %v, want %v", tt.dir, got, tt.want)
+		}
+	}
+}
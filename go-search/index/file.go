@@ -0,0 +1,173 @@
+package index
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// An index file is laid out as
+//
+//	magic    8 bytes, "GOSRCHIX"
+//	version  uint32, big endian
+//	hdrlen   uint32, big endian
+//	header   gob encoded Header, hdrlen bytes
+//	payload  gob encoded Index, Header.PayloadSize bytes
+//
+// The version is checked before anything is decoded. Gob quietly drops fields
+// it doesn't recognise, so any change to Index or DocTerm must bump
+// SchemaVersion or old files will load with empty fields. So must a change to
+// how terms are derived, or old files will miss queries.
+const (
+	Magic         = "GOSRCHIX"
+	SchemaVersion = 20
+)
+
+var (
+	ErrNotIndex = errors.New("index: not a go-search index file")
+	ErrChecksum = errors.New("index: payload checksum mismatch")
+)
+
+// A VersionError is returned when reading a file written with a different
+// schema version. The file has to be rebuilt with a matching parser.
+type VersionError struct {
+	Got, Want uint32
+}
+
+func (e VersionError) Error() string {
+	return fmt.Sprintf("index: schema version %v, want %v; rebuild the index", e.Got, e.Want)
+}
+
+// Header describes the contents of an index file
+type Header struct {
+	Created     time.Time
+	Stats       Stats
+	PayloadSize int64
+	Checksum    uint32 //CRC-32 (IEEE) of the payload
+}
+
+// Write serializes the index to w, preceded by its header
+func Write(w io.Writer, i *Index) error {
+	stats := i.Stats()
+	i.UniquePkgs = stats.Packages
+
+	var payload bytes.Buffer
+	if err := gob.NewEncoder(&payload).Encode(i); err != nil {
+		return err
+	}
+	var hdr bytes.Buffer
+	err := gob.NewEncoder(&hdr).Encode(Header{
+		Created:     time.Now(),
+		Stats:       stats,
+		PayloadSize: int64(payload.Len()),
+		Checksum:    crc32.ChecksumIEEE(payload.Bytes()),
+	})
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+	bw.WriteString(Magic)
+	binary.Write(bw, binary.BigEndian, uint32(SchemaVersion))
+	binary.Write(bw, binary.BigEndian, uint32(hdr.Len()))
+	bw.Write(hdr.Bytes())
+	bw.Write(payload.Bytes())
+	return bw.Flush()
+}
+
+// ReadHeader reads and checks the magic, version and header of an index file,
+// leaving r positioned at the start of the payload
+func ReadHeader(r io.Reader) (Header, error) {
+	var hdr Header
+	magic := make([]byte, len(Magic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != Magic {
+		return hdr, ErrNotIndex
+	}
+	var version, hdrLen uint32
+	if err := binary.Read(r, binary.BigEndian, &version); err != nil {
+		return hdr, err
+	}
+	if version != SchemaVersion {
+		return hdr, VersionError{Got: version, Want: SchemaVersion}
+	}
+	if err := binary.Read(r, binary.BigEndian, &hdrLen); err != nil {
+		return hdr, err
+	}
+	err := gob.NewDecoder(io.LimitReader(r, int64(hdrLen))).Decode(&hdr)
+	return hdr, err
+}
+
+// Read deserializes an index written by Write. The payload checksum and the
+// corpus statistics in the header are both verified against what was read.
+func Read(r io.Reader) (*Index, Header, error) {
+	hdr, err := ReadHeader(r)
+	if err != nil {
+		return nil, hdr, err
+	}
+	if hdr.PayloadSize < 0 {
+		return nil, hdr, fmt.Errorf("index: bad payload size %v", hdr.PayloadSize)
+	}
+	//read no more than is there, rather than trusting the header's size
+	payload, err := io.ReadAll(io.LimitReader(r, hdr.PayloadSize))
+	if err != nil {
+		return nil, hdr, err
+	}
+	if int64(len(payload)) != hdr.PayloadSize {
+		return nil, hdr, fmt.Errorf("index: truncated payload: %v of %v bytes", len(payload), hdr.PayloadSize)
+	}
+	if crc32.ChecksumIEEE(payload) != hdr.Checksum {
+		return nil, hdr, ErrChecksum
+	}
+
+	i := New()
+	if err := gob.NewDecoder(bytes.NewReader(payload)).Decode(i); err != nil {
+		return nil, hdr, err
+	}
+	if stats := i.Stats(); stats != hdr.Stats {
+		return nil, hdr, fmt.Errorf("index: header stats %+v do not match contents %+v", hdr.Stats, stats)
+	}
+	return i, hdr, nil
+}
+
+// Save writes the index to the named file. The file is replaced atomically so
+// a reader never sees a partial index, and is readable by everyone, as the
+// server may run as another user.
+func (i *Index) Save(name string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(name), filepath.Base(name)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := Write(tmp, i); err != nil {
+		tmp.Close()
+		return err
+	}
+	//CreateTemp makes the file private
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
+// Open reads the index stored in the named file
+func Open(name string) (*Index, Header, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, Header{}, err
+	}
+	defer file.Close()
+	return Read(bufio.NewReader(file))
+}
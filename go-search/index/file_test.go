@@ -0,0 +1,154 @@
+package index
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testIndex returns an index of the packages at paths, each using the terms
+// read and its own name once as a function and importing the package before
+// it, with a symbol and a dir stamp apiece
+func testIndex(paths ...string) *Index {
+	i := New()
+	for n, path := range paths {
+		name := filepath.Base(path)
+		for _, term := range []string{"read", name} {
+			if i.Index[term] == nil {
+				i.Index[term] = make(DocMap)
+			}
+			d := &DocTerm{Term: term, Pack: name, Path: path, Functions: 1}
+			d.AddLoc(Loc{Kind: KindFunc, Name: "Read", File: name + ".go", Line: 3})
+			i.Index[term][path] = d
+		}
+		p := i.Package(name, path)
+		if n > 0 {
+			p.AddImport(paths[n-1])
+		}
+		i.AddSymbol(&Symbol{Name: "Read", Kind: KindFunc, Pack: name, Path: path, File: name + ".go", Line: 3})
+		i.Dirs[path] = DirStamp{name + ".go": {ModTime: int64(n), Size: 42}}
+	}
+	i.UniquePkgs = len(paths)
+	return i
+}
+
+func TestRoundTrip(t *testing.T) {
+	want := testIndex("ex.com/a", "ex.com/b")
+	want.Other["test"] = DocMap{"ex.com/a": {Term: "test", Pack: "a", Path: "ex.com/a", Functions: 1}}
+	var buf bytes.Buffer
+	if err := Write(&buf, want); err != nil {
+		t.Fatal(err)
+	}
+	got, hdr, err := Read(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Read = %+v, want %+v", got, want)
+	}
+	if wantStats := (Stats{Terms: 4, Packages: 2, Postings: 5, Symbols: 2}); hdr.Stats != wantStats {
+		t.Errorf("header stats = %+v, want %+v", hdr.Stats, wantStats)
+	}
+}
+
+func TestSaveOpen(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "index.gob")
+	want := testIndex("ex.com/a")
+	if err := want.Save(name); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0644 {
+		t.Errorf("saved with mode %v, want 0644", perm)
+	}
+	got, _, err := Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Open = %+v, want %+v", got, want)
+	}
+}
+
+// rewriteHeader replaces the header of the index file data with the one edit
+// makes of it, keeping the payload as it is
+func rewriteHeader(t *testing.T, data []byte, edit func(*Header)) []byte {
+	r := bytes.NewReader(data)
+	hdr, err := ReadHeader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := data[len(data)-r.Len():]
+	edit(&hdr)
+	var enc bytes.Buffer
+	if err := gob.NewEncoder(&enc).Encode(hdr); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	out.WriteString(Magic)
+	binary.Write(&out, binary.BigEndian, uint32(SchemaVersion))
+	binary.Write(&out, binary.BigEndian, uint32(enc.Len()))
+	out.Write(enc.Bytes())
+	out.Write(payload)
+	return out.Bytes()
+}
+
+func TestReadCorrupt(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, testIndex("ex.com/a", "ex.com/b")); err != nil {
+		t.Fatal(err)
+	}
+	good := buf.Bytes()
+	corrupt := func(f func(data []byte) []byte) []byte {
+		return f(append([]byte(nil), good...))
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		want error  //compared with errors.Is, if set
+		msg  string //else contained in the error
+	}{
+		{"empty", nil, ErrNotIndex, ""},
+		{"bare gob", corrupt(func(d []byte) []byte { return d[len(Magic)+8:] }), ErrNotIndex, ""},
+		{"bad magic", corrupt(func(d []byte) []byte { d[0] = 'X'; return d }), ErrNotIndex, ""},
+		{"flipped payload byte", corrupt(func(d []byte) []byte { d[len(d)-5] ^= 0xff; return d }), ErrChecksum, ""},
+		{"truncated payload", corrupt(func(d []byte) []byte { return d[:len(d)-10] }), nil, "truncated payload"},
+		{"negative payload size", rewriteHeader(t, good, func(h *Header) { h.PayloadSize = -1 }), nil, "bad payload size"},
+		{"stats mismatch", rewriteHeader(t, good, func(h *Header) { h.Stats.Packages++ }), nil, "do not match contents"},
+	}
+	for _, tt := range tests {
+		_, _, err := Read(bytes.NewReader(tt.data))
+		switch {
+		case err == nil:
+			t.Errorf("%s: Read succeeded", tt.name)
+		case tt.want != nil && !errors.Is(err, tt.want):
+			t.Errorf("%s: Read error = %v, want %v", tt.name, err, tt.want)
+		case tt.want == nil && !strings.Contains(err.Error(), tt.msg):
+			t.Errorf("%s: Read error = %v, want %q", tt.name, err, tt.msg)
+		}
+	}
+}
+
+func TestReadVersion(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, testIndex("ex.com/a")); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	binary.BigEndian.PutUint32(data[len(Magic):], SchemaVersion-1)
+	_, _, err := Read(bytes.NewReader(data))
+	var verr VersionError
+	if !errors.As(err, &verr) || verr != (VersionError{Got: SchemaVersion - 1, Want: SchemaVersion}) {
+		t.Errorf("Read error = %v, want a VersionError for version %v", err, SchemaVersion-1)
+	}
+}
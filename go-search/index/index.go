@@ -0,0 +1,307 @@
+// Package index defines the inverted index shared by the go-search parsers and
+// the search server, along with the on-disk format used to pass it between
+// them.
+package index
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"go-search/analysis"
+)
+
+// A DocTerm counts the occurrences of a single term in a single package
+type DocTerm struct {
+	Term             string
+	Pack             string
+	Path             string //github import path -- should work with go get
+	Functions        int    //free functions, method names are counted in Methods
+	Methods          int    //method names and Type.Method qualified names
+	Imports          int
+	Packages         int
+	Types            int
+	Fields           int //struct field names and Type.Field qualified names
+	InterfaceMethods int //interface method names and Interface.Method qualified names
+	Consts           int //package level constants
+	Vars             int //package level variables
+	Comments         int //analyzed words in doc comments, only counted when parsing with -c
+	Locs             []Loc
+}
+
+// Kinds of declaration
+const (
+	KindFunc            = "func"
+	KindMethod          = "method"
+	KindType            = "type"
+	KindImport          = "import"
+	KindField           = "field"
+	KindInterfaceMethod = "imethod"
+	KindConst           = "const"
+	KindVar             = "var"
+)
+
+// Origins of code other than the package's production code, which has none
+const (
+	OriginTest    = "test"    //_test.go files
+	OriginIgnored = "ignored" //files excluded by build constraints
+)
+
+// A Loc is a declaration a term was found in
+type Loc struct {
+	Kind    string
+	Name    string //full name of the declaration
+	File    string //file name within the package dir
+	Line    int
+	Snippet string //the source line the declaration starts on
+	Origin  string `json:",omitempty"` //empty, OriginTest or OriginIgnored
+}
+
+// MaxLocs caps the number of locations kept for each term in each package
+const MaxLocs = 8
+
+// AddLoc records where the term was found, unless enough places already are
+func (d *DocTerm) AddLoc(l Loc) {
+	if len(d.Locs) < MaxLocs {
+		d.Locs = append(d.Locs, l)
+	}
+}
+
+//map full pkg paths to docterm data
+type DocMap map[string]*DocTerm
+
+func (d DocMap) String() string {
+	var pretty string
+	pretty += fmt.Sprintln("")
+	for k, v := range d {
+		pretty += fmt.Sprintln("        ", k, ": ", v)
+	}
+	return pretty
+}
+
+//map terms to the packages they occur in
+type IndexMap map[string]DocMap
+
+// A FileStamp records the state of a source file when it was last indexed
+type FileStamp struct {
+	ModTime int64
+	Size    int64
+}
+
+// A DirStamp maps the names of the .go files in a package dir to their stamps
+type DirStamp map[string]FileStamp
+
+func (d DirStamp) Equal(o DirStamp) bool {
+	if len(d) != len(o) {
+		return false
+	}
+	for name, fs := range d {
+		if ofs, ok := o[name]; !ok || ofs != fs {
+			return false
+		}
+	}
+	return true
+}
+
+// A Symbol is an exported declaration that can be searched for on its own
+type Symbol struct {
+	Name      string
+	Recv      string //receiver type name for methods, the enclosing type for fields and interface methods
+	Kind      string //any of the kinds of declaration but KindImport
+	Pack      string
+	Path      string
+	Signature string //the declaration without its body
+	File      string
+	Line      int
+	Origin    string    `json:",omitempty"` //empty, OriginTest or OriginIgnored
+	Examples  []Example `json:",omitempty"`
+	//the normalised types of a function's or method's parameters and
+	//results, see go-search/shape
+	Params  []string `json:",omitempty"`
+	Results []string `json:",omitempty"`
+	//the constraints of a generic function's type parameters
+	TypeParams []string `json:",omitempty"`
+}
+
+// An Example is an Example function from a package's tests, showing how to
+// use the package or one of its symbols
+type Example struct {
+	Name   string //the function's name less Example, e.g. Reader_Read_basic
+	Doc    string
+	Code   string //the body of the function, without its output comment
+	Output string //the output the example is checked against, if any
+	File   string
+	Line   int
+}
+
+// ID uniquely identifies a symbol within an index
+func (s *Symbol) ID() string {
+	if s.Recv != "" {
+		return s.Path + "." + s.Recv + "." + s.Name
+	}
+	return s.Path + "." + s.Name
+}
+
+// FullName is the name the symbol is referred to by from another package,
+// such as ioutil.ReadAll or bufio.Reader.Read
+func (s *Symbol) FullName() string {
+	if s.Recv != "" {
+		return s.Pack + "." + s.Recv + "." + s.Name
+	}
+	return s.Pack + "." + s.Name
+}
+
+// A Package records what is known about a package as a whole
+type Package struct {
+	Name    string
+	Path    string
+	Imports []string //import paths, sorted
+	Module  string   //path of the module the package belongs to, if any
+	Version string   //version of the module, if known
+	//the first sentence of the package comment
+	Synopsis string `json:",omitempty"`
+	//examples of the package as a whole, and of symbols that weren't indexed
+	Examples []Example `json:",omitempty"`
+}
+
+// AddImport records that the package imports path
+func (p *Package) AddImport(path string) {
+	i := sort.SearchStrings(p.Imports, path)
+	if i < len(p.Imports) && p.Imports[i] == path {
+		return
+	}
+	p.Imports = append(p.Imports, "")
+	copy(p.Imports[i+1:], p.Imports[i:])
+	p.Imports[i] = path
+}
+
+type Index struct {
+	Index IndexMap
+	//postings from test files and files excluded by build constraints, kept
+	//apart so production code can be searched on its own
+	Other      IndexMap
+	UniquePkgs int
+	//map package paths to the state of their dir at index time
+	Dirs map[string]DirStamp
+	//map symbol IDs to symbols
+	Symbols map[string]*Symbol
+	//map package paths to packages
+	Packages map[string]*Package
+	//map type IDs to named types, only filled in when TypeChecked
+	Types map[string]*NamedType
+	//how comments were turned into terms
+	Analysis analysis.Config
+	//the build settings files were matched against
+	Constraints Constraints
+	//whether the parser type checked packages to fill in Types
+	TypeChecked bool
+	//PageRank of each package over the import graph of the whole corpus,
+	//only set on shards, whose own packages form just part of the graph
+	Ranks map[string]float64
+}
+
+// Constraints are the build settings that tell production code from files
+// excluded by build constraints
+type Constraints struct {
+	GOOS   string
+	GOARCH string
+	Tags   string //comma separated
+}
+
+// New returns an empty index ready to be filled in
+func New() *Index {
+	return &Index{
+		Index:    make(IndexMap),
+		Other:    make(IndexMap),
+		Dirs:     make(map[string]DirStamp),
+		Symbols:  make(map[string]*Symbol),
+		Packages: make(map[string]*Package),
+		Types:    make(map[string]*NamedType),
+	}
+}
+
+// Package returns the package stored under path, adding it if it's new
+func (i *Index) Package(name, path string) *Package {
+	p, ok := i.Packages[path]
+	if !ok {
+		p = &Package{Name: name, Path: path}
+		i.Packages[path] = p
+	} else if strings.HasSuffix(p.Name, "_test") {
+		//a dir can hold an external test package alongside the real one
+		p.Name = name
+	}
+	return p
+}
+
+// AddSymbol adds a symbol to the index, replacing any with the same ID
+func (i *Index) AddSymbol(s *Symbol) {
+	i.Symbols[s.ID()] = s
+}
+
+func (i *Index) String() string {
+	var pretty string
+	for k, v := range i.Index {
+		pretty += fmt.Sprintln(k, ": ", v)
+	}
+	return pretty
+}
+
+// Stats summarises the size of an index
+type Stats struct {
+	Terms    int
+	Packages int
+	Postings int
+	Symbols  int
+}
+
+// Stats counts the terms, unique packages, postings and symbols in the index,
+// in production code and elsewhere
+func (i *Index) Stats() Stats {
+	s := Stats{Terms: len(i.Index), Symbols: len(i.Symbols)}
+	pkgs := make(map[string]struct{})
+	for _, m := range []IndexMap{i.Index, i.Other} {
+		for term, docMap := range m {
+			if _, ok := i.Index[term]; !ok {
+				s.Terms++ //only found outside production code
+			}
+			s.Postings += len(docMap)
+			for path := range docMap {
+				pkgs[path] = struct{}{}
+			}
+		}
+	}
+	s.Packages = len(pkgs)
+	return s
+}
+
+// RemovePaths drops every posting, symbol and type for the given package
+// paths, along with any terms left without postings
+func (i *Index) RemovePaths(paths map[string]struct{}) {
+	if len(paths) == 0 {
+		return
+	}
+	for id, sym := range i.Symbols {
+		if _, ok := paths[sym.Path]; ok {
+			delete(i.Symbols, id)
+		}
+	}
+	for path := range paths {
+		delete(i.Packages, path)
+		delete(i.Ranks, path)
+	}
+	for id, t := range i.Types {
+		if _, ok := paths[t.Path]; ok {
+			delete(i.Types, id)
+		}
+	}
+	for _, m := range []IndexMap{i.Index, i.Other} {
+		for term, docMap := range m {
+			for path := range paths {
+				delete(docMap, path)
+			}
+			if len(docMap) == 0 {
+				delete(m, term)
+			}
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package index
+
+import (
+	"fmt"
+	"hash/fnv"
+	"path/filepath"
+	"strings"
+)
+
+// Merge folds o into i. Postings for the same term and package are summed,
+// so o may be a partial index built from a disjoint or overlapping set of
+// dirs. Symbols, types, packages and dir stamps in o replace or join those
+// in i, and UniquePkgs is recomputed. o should not be used afterwards, as i
+// may share its postings. Indexes whose comments were analyzed differently or whose
+// files were matched against different build constraints can't be merged.
+func (i *Index) Merge(o *Index) error {
+	if len(i.Index) == 0 && len(i.Other) == 0 && len(i.Packages) == 0 {
+		i.Analysis, i.Constraints, i.TypeChecked = o.Analysis, o.Constraints, o.TypeChecked
+	} else if len(o.Index)+len(o.Other) > 0 {
+		if o.Analysis != i.Analysis {
+			return fmt.Errorf("index: can't merge comments analyzed with %+v into %+v", o.Analysis, i.Analysis)
+		}
+		if o.Constraints != i.Constraints {
+			return fmt.Errorf("index: can't merge files built for %+v into %+v", o.Constraints, i.Constraints)
+		}
+	}
+
+	mergePostings(i.Index, o.Index)
+	mergePostings(i.Other, o.Other)
+	for id, sym := range o.Symbols {
+		i.Symbols[id] = sym
+	}
+	for id, t := range o.Types {
+		i.Types[id] = t
+	}
+	for path, r := range o.Ranks {
+		if i.Ranks == nil {
+			i.Ranks = make(map[string]float64)
+		}
+		i.Ranks[path] = r
+	}
+	for path, pkg := range o.Packages {
+		p := i.Package(pkg.Name, path)
+		if pkg.Module != "" {
+			p.Module, p.Version = pkg.Module, pkg.Version
+		}
+		if len(pkg.Examples) > 0 {
+			p.Examples = pkg.Examples
+		}
+		if pkg.Synopsis != "" {
+			p.Synopsis = pkg.Synopsis
+		}
+		for _, imp := range pkg.Imports {
+			p.AddImport(imp)
+		}
+	}
+	for path, stamp := range o.Dirs {
+		i.Dirs[path] = stamp
+	}
+	i.UniquePkgs = i.Stats().Packages
+	return nil
+}
+
+// mergePostings folds the postings of src into dst, summing those for the
+// same term and package. dst may share postings with src afterwards.
+func mergePostings(dst, src IndexMap) {
+	for term, docMap := range src {
+		mine, ok := dst[term]
+		if !ok {
+			dst[term] = docMap
+			continue
+		}
+		for path, docTerm := range docMap {
+			if d, ok := mine[path]; ok {
+				d.add(docTerm)
+			} else {
+				mine[path] = docTerm
+			}
+		}
+	}
+}
+
+// All returns a view of i whose postings cover test files and files excluded
+// by build constraints along with production code. i is left as it is, and
+// shares any postings it can with the view.
+func (i *Index) All() *Index {
+	if len(i.Other) == 0 {
+		return i
+	}
+	all := *i
+	all.Index = make(IndexMap, len(i.Index))
+	for term, docMap := range i.Index {
+		all.Index[term] = docMap
+	}
+	for term, docMap := range i.Other {
+		mine, ok := all.Index[term]
+		if !ok {
+			all.Index[term] = docMap
+			continue
+		}
+		merged := make(DocMap, len(mine)+len(docMap))
+		for path, d := range mine {
+			merged[path] = d
+		}
+		for path, docTerm := range docMap {
+			if d, ok := merged[path]; ok {
+				sum := *d
+				sum.Locs = append([]Loc(nil), d.Locs...)
+				sum.add(docTerm)
+				merged[path] = &sum
+			} else {
+				merged[path] = docTerm
+			}
+		}
+		all.Index[term] = merged
+	}
+	all.Other = make(IndexMap)
+	return &all
+}
+
+// Production returns a view of i holding only the postings of production
+// code. Symbols and packages are shared with i.
+func (i *Index) Production() *Index {
+	if len(i.Other) == 0 {
+		return i
+	}
+	p := *i
+	p.Other = make(IndexMap)
+	p.UniquePkgs = p.Stats().Packages
+	return &p
+}
+
+// add sums the counts and locations of o into d
+func (d *DocTerm) add(o *DocTerm) {
+	d.Functions += o.Functions
+	d.Methods += o.Methods
+	d.Imports += o.Imports
+	d.Packages += o.Packages
+	d.Types += o.Types
+	d.Fields += o.Fields
+	d.InterfaceMethods += o.InterfaceMethods
+	d.Consts += o.Consts
+	d.Vars += o.Vars
+	d.Comments += o.Comments
+	for _, l := range o.Locs {
+		d.AddLoc(l)
+	}
+}
+
+// ShardFile names the nth of a set of shard files written in place of the
+// index file name, e.g. index.gob becomes index.0.gob, index.1.gob, ...
+func ShardFile(name string, n int) string {
+	ext := filepath.Ext(name)
+	return fmt.Sprintf("%s.%d%s", strings.TrimSuffix(name, ext), n, ext)
+}
+
+// ShardOf assigns the package at path to one of n shards. The assignment
+// only depends on the path, so a package lands in the same shard every time
+// the index is built and packages spread evenly over the shards.
+func ShardOf(path string, n int) int {
+	h := fnv.New32a()
+	h.Write([]byte(path))
+	return int(h.Sum32() % uint32(n))
+}
+
+// Split divides i into n disjoint indexes by ShardOf the package path of
+// each posting, symbol, type, package and dir stamp. The parts share their
+// contents with i.
+func (i *Index) Split(n int) []*Index {
+	parts := make([]*Index, n)
+	for k := range parts {
+		parts[k] = New()
+		parts[k].Analysis, parts[k].Constraints, parts[k].TypeChecked = i.Analysis, i.Constraints, i.TypeChecked
+	}
+	split := func(m IndexMap, part func(*Index) IndexMap) {
+		for term, docMap := range m {
+			for path, docTerm := range docMap {
+				pm := part(parts[ShardOf(path, n)])
+				if pm[term] == nil {
+					pm[term] = make(DocMap)
+				}
+				pm[term][path] = docTerm
+			}
+		}
+	}
+	split(i.Index, func(p *Index) IndexMap { return p.Index })
+	split(i.Other, func(p *Index) IndexMap { return p.Other })
+	for id, sym := range i.Symbols {
+		parts[ShardOf(sym.Path, n)].Symbols[id] = sym
+	}
+	for path, pkg := range i.Packages {
+		parts[ShardOf(path, n)].Packages[path] = pkg
+	}
+	for id, t := range i.Types {
+		parts[ShardOf(t.Path, n)].Types[id] = t
+	}
+	for path, r := range i.Ranks {
+		p := parts[ShardOf(path, n)]
+		if p.Ranks == nil {
+			p.Ranks = make(map[string]float64)
+		}
+		p.Ranks[path] = r
+	}
+	for path, stamp := range i.Dirs {
+		parts[ShardOf(path, n)].Dirs[path] = stamp
+	}
+	for _, p := range parts {
+		p.UniquePkgs = p.Stats().Packages
+	}
+	return parts
+}
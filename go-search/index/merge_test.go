@@ -0,0 +1,143 @@
+package index
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"go-search/analysis"
+)
+
+func TestMerge(t *testing.T) {
+	i := testIndex("ex.com/a", "ex.com/b")
+	o := testIndex("ex.com/b", "ex.com/c")
+	if err := i.Merge(o); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		term, path string
+		funcs      int //0 if there should be no posting
+		locs       int
+	}{
+		//both indexes hold read for ex.com/b, so its counts are summed
+		{"read", "ex.com/a", 1, 1},
+		{"read", "ex.com/b", 2, 2},
+		{"read", "ex.com/c", 1, 1},
+		{"b", "ex.com/b", 2, 2},
+		{"a", "ex.com/a", 1, 1},
+		{"c", "ex.com/c", 1, 1},
+		{"a", "ex.com/c", 0, 0},
+	}
+	for _, tt := range tests {
+		d := i.Index[tt.term][tt.path]
+		switch {
+		case d == nil && tt.funcs != 0:
+			t.Errorf("no posting of %s in %s", tt.term, tt.path)
+		case d != nil && (d.Functions != tt.funcs || len(d.Locs) != tt.locs):
+			t.Errorf("%s in %s: %v functions at %v locs, want %v at %v", tt.term, tt.path, d.Functions, len(d.Locs), tt.funcs, tt.locs)
+		}
+	}
+	if want := (Stats{Terms: 4, Packages: 3, Postings: 6, Symbols: 3}); i.Stats() != want {
+		t.Errorf("Stats = %+v, want %+v", i.Stats(), want)
+	}
+	if i.UniquePkgs != 3 || len(i.Packages) != 3 || len(i.Dirs) != 3 {
+		t.Errorf("merged %v unique packages, %v packages and %v dirs, want 3 of each", i.UniquePkgs, len(i.Packages), len(i.Dirs))
+	}
+	if got := i.Packages["ex.com/c"].Imports; !reflect.DeepEqual(got, []string{"ex.com/b"}) {
+		t.Errorf("ex.com/c imports %q, want [ex.com/b]", got)
+	}
+}
+
+func TestMergeMismatch(t *testing.T) {
+	stemmed := func() *Index {
+		i := testIndex("ex.com/b")
+		i.Analysis = analysis.Config{Stem: true}
+		return i
+	}
+	linux := func() *Index {
+		i := testIndex("ex.com/b")
+		i.Constraints = Constraints{GOOS: "linux", GOARCH: "amd64"}
+		return i
+	}
+
+	//an empty index takes on the settings of the first it merges
+	i := New()
+	if err := i.Merge(stemmed()); err != nil || i.Analysis != (analysis.Config{Stem: true}) {
+		t.Errorf("merge into empty index: %v, analysis %+v", err, i.Analysis)
+	}
+	for _, o := range []*Index{stemmed(), linux()} {
+		if err := testIndex("ex.com/a").Merge(o); err == nil {
+			t.Errorf("merged %+v %+v into the defaults", o.Analysis, o.Constraints)
+		}
+	}
+}
+
+func TestSplit(t *testing.T) {
+	var paths []string
+	for n := 0; n < 20; n++ {
+		paths = append(paths, fmt.Sprintf("ex.com/p%d", n))
+	}
+	i := testIndex(paths...)
+	i.Ranks = PageRank(i.Packages)
+	const n = 3
+	parts := i.Split(n)
+	if len(parts) != n {
+		t.Fatalf("Split(%v) made %v parts", n, len(parts))
+	}
+
+	merged := New()
+	for k, p := range parts {
+		for path := range p.Packages {
+			if ShardOf(path, n) != k {
+				t.Errorf("%s in part %v, want %v", path, k, ShardOf(path, n))
+			}
+			if _, ok := p.Dirs[path]; !ok {
+				t.Errorf("%s's dir stamp isn't in its part", path)
+			}
+			if p.Ranks[path] != i.Ranks[path] {
+				t.Errorf("%s ranks %v in its part, want %v", path, p.Ranks[path], i.Ranks[path])
+			}
+		}
+		for _, sym := range p.Symbols {
+			if ShardOf(sym.Path, n) != k {
+				t.Errorf("symbol of %s in part %v, want %v", sym.Path, k, ShardOf(sym.Path, n))
+			}
+		}
+		for term, docMap := range p.Index {
+			for path := range docMap {
+				if ShardOf(path, n) != k {
+					t.Errorf("%s in %s in part %v, want %v", term, path, k, ShardOf(path, n))
+				}
+			}
+		}
+		if p.UniquePkgs != len(p.Packages) {
+			t.Errorf("part %v counts %v unique packages, holds %v", k, p.UniquePkgs, len(p.Packages))
+		}
+		if err := merged.Merge(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	//the parts are disjoint, so merging them back loses and sums nothing
+	if merged.Stats() != i.Stats() {
+		t.Errorf("merged parts have stats %+v, want %+v", merged.Stats(), i.Stats())
+	}
+}
+
+func TestShardOf(t *testing.T) {
+	seen := make(map[int]bool)
+	for n := 0; n < 100; n++ {
+		path := fmt.Sprintf("ex.com/p%d", n)
+		k := ShardOf(path, 4)
+		if k < 0 || k >= 4 {
+			t.Fatalf("ShardOf(%q, 4) = %v", path, k)
+		}
+		if ShardOf(path, 4) != k {
+			t.Errorf("ShardOf(%q, 4) changed", path)
+		}
+		seen[k] = true
+	}
+	if len(seen) != 4 {
+		t.Errorf("100 paths landed in %v of 4 shards", len(seen))
+	}
+}
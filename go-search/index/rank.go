@@ -0,0 +1,50 @@
+package index
+
+const (
+	damping        = 0.85
+	rankIterations = 30
+)
+
+// PageRank computes the PageRank of every package over the import graph,
+// scaled so the average package has a rank of 1. Imports of packages that
+// aren't among packages are ignored.
+func PageRank(packages map[string]*Package) map[string]float64 {
+	n := float64(len(packages))
+	rank := make(map[string]float64, len(packages))
+	if n == 0 {
+		return rank
+	}
+	for path := range packages {
+		rank[path] = 1 / n
+	}
+
+	for iter := 0; iter < rankIterations; iter++ {
+		next := make(map[string]float64, len(rank))
+		dangling := 0.0
+		for path, pkg := range packages {
+			var deps []string
+			for _, dep := range pkg.Imports {
+				if _, ok := packages[dep]; ok {
+					deps = append(deps, dep)
+				}
+			}
+			if len(deps) == 0 {
+				dangling += rank[path]
+				continue
+			}
+			share := rank[path] / float64(len(deps))
+			for _, dep := range deps {
+				next[dep] += share
+			}
+		}
+		for path := range packages {
+			next[path] = (1-damping)/n + damping*(next[path]+dangling/n)
+		}
+		rank = next
+	}
+
+	for path := range rank {
+		rank[path] *= n
+	}
+	return rank
+}
@@ -0,0 +1,46 @@
+package index
+
+// A NamedType is a named type and its method set, as found by the parser's
+// optional type checking pass. Besides the types of the indexed packages, the
+// exported interfaces of the packages they import are kept, so that
+// implementations of io.Reader can be found without indexing io.
+type NamedType struct {
+	Name      string
+	Path      string //import path of the package declaring the type
+	Interface bool
+	//the method set of *T, or of the interface itself, sorted by name
+	Methods []Method
+}
+
+// A Method is one method in the method set of a named type
+type Method struct {
+	//the method's name, qualified by its package path if unexported, as
+	//unexported methods of different packages never match
+	Name string
+	//the types of the parameters and results, with package paths in full,
+	//e.g. ([]byte) (int, error)
+	Signature string
+	//set if the method has a pointer receiver, so only *T has it
+	Pointer bool `json:",omitempty"`
+}
+
+// ID identifies a named type within an index, e.g. net/http.Handler
+func (t *NamedType) ID() string {
+	return t.Path + "." + t.Name
+}
+
+// Implements reports whether the method set of t satisfies the interface
+// iface, and if so whether only *t does
+func (t *NamedType) Implements(iface *NamedType) (ok, pointer bool) {
+	i := 0
+	for _, m := range iface.Methods {
+		for i < len(t.Methods) && t.Methods[i].Name < m.Name {
+			i++
+		}
+		if i == len(t.Methods) || t.Methods[i].Name != m.Name || t.Methods[i].Signature != m.Signature {
+			return false, false
+		}
+		pointer = pointer || t.Methods[i].Pointer
+	}
+	return true, pointer
+}
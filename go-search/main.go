@@ -5,22 +5,72 @@ import (
 	"log"
 	"net/http"
 	"flag"
+	"os"
+	"os/signal"
+	"syscall"
 
+	"go-search/cluster"
 	"go-search/server"
 	"go-search/search"
 )
 
-const (
-	listenAddr = ":8000"
-	indexFile = "./parser/index.gob"
+var (
+	listenAddr  = flag.String("listen", ":8000", "address to serve on")
+	indexFile   = flag.String("index", "./parser/index.gob", "index file to serve")
+	clusterFile = flag.String("cluster", "", "cluster config file; run as the coordinator of its shards, or as one of them with -shard")
+	shardName   = flag.String("shard", "", "name of the shard in the -cluster config to serve, which sets -listen and -index")
+	watch       = flag.Duration("watch", 0, "reload the index when its file changes, checking this often; 0 to only reload on SIGHUP or /admin/reload")
 )
 
 func main() {
 	flag.Parse()
-    search.OpenIndex(indexFile)
+	if *clusterFile != "" {
+		config, err := cluster.LoadConfig(*clusterFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if *shardName == "" {
+			coordinate(config)
+			return
+		}
+		shard, ok := config.Shard(*shardName)
+		if !ok {
+			log.Fatalf("no shard %q in %v", *shardName, *clusterFile)
+		}
+		*listenAddr, *indexFile = shard.Addr, shard.Index
+		log.Printf("Serving shard %v", shard.Name)
+	}
+
+	if err := search.OpenIndex(*indexFile); err != nil {
+		log.Fatal(err)
+	}
+	go reloadOnHangup()
+	if *watch > 0 {
+		go search.Watch(*watch)
+	}
 
 	server.RegisterHandlers()
 	http.Handle("/", http.FileServer(http.Dir("static")))
-	log.Println("Listening at", listenAddr)
-	http.ListenAndServe(listenAddr, nil)
+	log.Println("Listening at", *listenAddr)
+	http.ListenAndServe(*listenAddr, nil)
+}
+
+// coordinate serves searches across the shards listed in config
+func coordinate(config *cluster.Config) {
+	server.RegisterCoordinator(cluster.NewCoordinator(config))
+	http.Handle("/", http.FileServer(http.Dir("static")))
+	log.Printf("Coordinating %v shards, listening at %v", len(config.Shards), *listenAddr)
+	log.Fatal(http.ListenAndServe(*listenAddr, nil))
+}
+
+// reloadOnHangup reloads the index each time the process gets SIGHUP
+func reloadOnHangup() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		log.Println("SIGHUP, reloading index")
+		if _, err := search.Reload(); err != nil {
+			log.Println("Reload failed:", err)
+		}
+	}
 }
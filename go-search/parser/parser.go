@@ -1,7 +1,6 @@
 package main
 
 import (
-	"encoding/gob"
 	"flag"
 	"fmt"
 	"go/ast"
@@ -13,7 +12,10 @@ import (
 	_"runtime"
 	"strings"
 	"time"
-	"unicode"
+
+	"go-search/analysis"
+	"go-search/index"
+	"go-search/tokenizer"
 )
 
 var (
@@ -21,99 +23,49 @@ var (
 	maxDirs   = flag.Int("max", -1, "Maximum # of files to parse")
 	verbose   = flag.Int("v", 0, "Print the resulting index map")
 	commentParse = flag.Bool("c", false, "Parse with comments?")
-	index     = Index{Index:make(map[string]DocMap)}
+	idx       = index.New()
+	analyzer  = analysis.New(analysis.DefaultConfig)
 )
 
-const dbfile = "./index.gob"
-
-type DocTerm struct {
-	Title     string
-	Path      string //github import path -- should work with go get
-	Functions int
-	Imports   int
-	Packages  int
-	Types     int
-	//Comments  int
-}
-
-//map full pkg paths to docterm data
-type DocMap map[string]*DocTerm
-
-func (d DocMap) String() string {
-	var pretty string
-	pretty += fmt.Sprintln("")
-	for k, v := range d {
-		pretty += fmt.Sprintln("        ", k, ": ", v)
-	}
-	return pretty
-}
-
-type Index struct {
-    Index map[string]DocMap
-    UniquePkgs int
-}
-
-func (i Index) String() string {
-	var pretty string
-	for k, v := range i.Index {
-		pretty += fmt.Sprintln(k, ": ", v)
-	}
-	return pretty
-}
+// The sequential indexer only records term counts, with methods counted as
+// functions and no packages, symbols or dir stamps, so it writes no index
+// file at all: the server can't be served a file short of what the schema
+// version promises. It is kept to time and compare against the concurrent
+// indexer, whose index files are the only ones there are.
 
-func (i Index) Save() {
-	file, err := os.Create(dbfile)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	log.Printf("Serializing index of size %v to file", len(i.Index))
-
-	enc := gob.NewEncoder(file)
-	err = enc.Encode(i)
-	if err != nil {
-		log.Fatal(err)
-	}
-	file.Close()
-}
-
-func updateIndex(term string, path string) *DocTerm {
+func updateIndex(term string, pack string, path string) *index.DocTerm {
 	term = strings.TrimSpace(term)
 	term = strings.ToLower(term)
 
-	docMap, present := index.Index[term]
+	docMap, present := idx.Index[term]
 	if !present {
 		// new DocMap
-		index.Index[term] = make(DocMap)
-		docMap = index.Index[term]
+		idx.Index[term] = make(index.DocMap)
+		docMap = idx.Index[term]
 	}
 	_, present = docMap[path]
 	if !present {
 		//new docTerm
-		docMap[path] = &DocTerm{
-			Title:     term,
+		docMap[path] = &index.DocTerm{
+			Term:      term,
+			Pack:      pack,
 			Path:      path,
 			Functions: 0,
 			Imports:   0,
 			Packages:  0,
 			Types:     0,
+			Comments:  0,
 		}
 	}
 	return docMap[path]
 }
 
-func tokenizeCamelCase(str string) []string {
-	var words []string
-	l := 0
-	for s := str; s != ""; s = s[l:] {
-		l = strings.IndexFunc(s[1:], unicode.IsUpper) + 1
-		if l <= 0 {
-			l = len(s)
-		}
-		words = append(words, s[:l])
+// indexComment adds the analyzed words of a doc comment to the index
+func indexComment(doc *ast.CommentGroup, pack string, path string) {
+	for _, word := range analyzer.Analyze(doc.Text()) {
+		docTerm := updateIndex(word, pack, path)
+		docTerm.Comments += 1
 	}
-
-	return words
 }
 
 // This is a long function definitions spanning multiple
@@ -122,16 +74,23 @@ func tokenizeCamelCase(str string) []string {
 func indexPackages(pkgs map[string]*ast.Package, prefix string) error {
 	for name, pkg := range pkgs {
 		path := prefix + "/" + name
-		//fmt.Println("Inspecting ", path)
+		pack := name
+		//fmt.Println("Inspecting ", pack, path)
 
 		ast.Inspect(pkg, func(n ast.Node) bool {
 
 			switch x := n.(type) {
+			//Package docs
+			case *ast.File:
+				if x.Doc != nil && *commentParse {
+					indexComment(x.Doc, pack, path)
+				}
+
 			//Packages
 			case *ast.Package:
 				if x.Name != "" {
 					//update index and docMap if necessary
-					docTerm := updateIndex(x.Name, path)
+					docTerm := updateIndex(x.Name, pack, path)
 					//update docTerm
 					docTerm.Packages += 1
 				}
@@ -141,7 +100,7 @@ func indexPackages(pkgs map[string]*ast.Package, prefix string) error {
 			case *ast.ImportSpec:
 				if x.Path.Value != "" {
 					//update index and docMap if necessary
-					docTerm := updateIndex(strings.Replace(x.Path.Value, "\"", "", -1), path)
+					docTerm := updateIndex(strings.Replace(x.Path.Value, "\"", "", -1), pack, path)
 					//update docTerm
 					docTerm.Imports += 1
 				}
@@ -151,30 +110,16 @@ func indexPackages(pkgs map[string]*ast.Package, prefix string) error {
 			case *ast.FuncDecl:
 				if x.Name.Name != "" {
 					//Name tokenize function
-					for _, n := range tokenizeCamelCase(x.Name.Name) {
+					for _, n := range tokenizer.Words(x.Name.Name) {
 						//update index and docMap if necessary
-						docTerm := updateIndex(n, path)
+						docTerm := updateIndex(n, pack, path)
 						//update docTerm
 						docTerm.Functions += 1
 					}
 
 					//Add comments to index
-					if x.Doc != nil && *commentParse{
-						comment := ""
-						for _, c := range x.Doc.List {
-							comment += c.Text
-						}
-
-						comment = strings.Replace(comment, "//", "", -1)
-						comment = strings.ToLower(comment)
-
-
-						words := strings.Fields(comment)
-
-						for _, word := range words {
-							docTerm := updateIndex(word, path)
-							docTerm.Functions += 1
-						}
+					if x.Doc != nil && *commentParse {
+						indexComment(x.Doc, pack, path)
 					}
 				}
 				break
@@ -182,29 +127,16 @@ func indexPackages(pkgs map[string]*ast.Package, prefix string) error {
 			case *ast.TypeSpec:
 				if x.Name.Name != "" {
 					//Name tokenize function
-					for _, n := range tokenizeCamelCase(x.Name.Name) {
+					for _, n := range tokenizer.Words(x.Name.Name) {
 						//update index and docMap if necessary
-						docTerm := updateIndex(n, path)
+						docTerm := updateIndex(n, pack, path)
 						//update docTerm
 						docTerm.Types += 1
 					}
 
 					//Add comments to index
 					if x.Doc != nil && *commentParse {
-						comment := ""
-						for _, c := range x.Doc.List {
-							comment += c.Text
-						}
-
-						comment = strings.Replace(comment, "//", "", -1)
-						comment = strings.ToLower(comment)
-
-						words := strings.Fields(comment)
-
-						for _, word := range words {
-							docTerm := updateIndex(word, path)
-							docTerm.Types += 1
-						}
+						indexComment(x.Doc, pack, path)
 					}
 				}
 				break
@@ -301,27 +233,16 @@ func main() {
 
 	//Output the results if flag is set
 	if *verbose == 1 {
-		fmt.Printf("%v", index.Index)
+		fmt.Printf("%v", idx.Index)
 	}
 
 
     //Count the number of packages
-    results := make(map[string]bool, 0)
-    
-    for _, docMap := range index.Index {
-        for path, _ := range docMap {
-            results[path] = true
-        }
-    }
-    
-    index.UniquePkgs = len(results)
-    
-
-    //Save index to file
-	index.Save()
-    
-    
-    fmt.Printf("\n\nIndexed %v unique terms in %v packages in %v\n\n", len(index.Index), len(results), endTime.Sub(startTime))
+    idx.Analysis = analyzer.Config
+    stats := idx.Stats()
+    idx.UniquePkgs = stats.Packages
+
+    fmt.Printf("\n\nIndexed %v unique terms in %v packages in %v\n\n", stats.Terms, stats.Packages, endTime.Sub(startTime))
 
 	if *verbose != 1 {
 		fmt.Printf("Use -v 1 to print the resulting index map \n\n")
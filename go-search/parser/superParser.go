@@ -1,21 +1,39 @@
 package main
 
 import (
-	"encoding/gob"
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
 	"errors"
 	"fmt"
 	"go/ast"
+	"go/build"
+	"go/doc"
+	"go/importer"
 	"go/parser"
+	"go/printer"
 	"go/token"
+	"go/types"
+	"io"
 	"log"
 	"os"
+	"path"
 	"path/filepath"
+	"regexp"
 	"runtime"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 	"unicode"
 	"flag"
+
+	"go-search/analysis"
+	"go-search/importpath"
+	"go-search/index"
+	"go-search/shape"
+	"go-search/tokenizer"
 )
 
 const (
@@ -24,76 +42,37 @@ const (
 )
 
 var (
-	index Index = Index{Index: make(IndexMap)}
+	idx          = index.New()
 	commentParse = flag.Bool("c", false, "Parse with comments?")
-	inputPath = flag.String("in", "", "Input file to parse")
+	inputPath = flag.String("in", "", "Input dir or module archive (.zip, .tar, .tar.gz) to parse; more may follow the flags")
+	incremental = flag.Bool("incr", false, "Only re-index packages that changed since the last run")
+	stem         = flag.Bool("stem", analysis.DefaultConfig.Stem, "Stem words in comments?")
+	stopWords    = flag.Bool("stop", analysis.DefaultConfig.StopWords, "Drop English stop words from comments?")
+	shards       = flag.Int("shards", 0, "Write the index as this many shard files instead of one")
+	goos         = flag.String("goos", build.Default.GOOS, "GOOS to evaluate build constraints for")
+	goarch       = flag.String("goarch", build.Default.GOARCH, "GOARCH to evaluate build constraints for")
+	buildTags    = flag.String("tags", "", "Comma separated build tags to evaluate build constraints with")
+	typeCheck    = flag.Bool("types", false, "Type check packages to record the method sets of their types")
+	analyzer     *analysis.Analyzer
+	//matches files against the build constraints set by the flags above
+	buildContext build.Context
 )
 
-type DocTerm struct {
-	Term      string
-    Pack      string
-	Path      string //github import path -- should work with go get
-	Functions int
-	Imports   int
-	Packages  int
-	Types     int
-}
-type DocMap map[string]*DocTerm
-
-func (d DocMap) String() string {
-	var pretty string
-	pretty += fmt.Sprintln("")
-	for k, v := range d {
-		pretty += fmt.Sprintln("        ", k, ": ", v)
-	}
-	return pretty
-}
-
-type IndexMap map[string]DocMap
-
-type Index struct {
-	Index      IndexMap
-	UniquePkgs int
-}
-
-func (i Index) String() string {
-	var pretty string
-	for k, v := range i.Index {
-		pretty += fmt.Sprintln(k, ": ", v)
-	}
-	return pretty
-}
-
-func (i Index) Save() {
-	file, err := os.Create(indexFile)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	log.Printf("Serializing index of size %v to file", len(i.Index))
-
-	enc := gob.NewEncoder(file)
-	err = enc.Encode(i)
-	if err != nil {
-		log.Fatal(err)
-	}
-	file.Close()
-}
-
-func updateIndex(term string, pack string, path string) *DocTerm {
+// updateIndex returns the posting of term for path in terms, adding it if new
+func updateIndex(terms index.IndexMap, term string, pack string, path string) *index.DocTerm {
 	term = strings.TrimSpace(term)
 	term = strings.ToLower(term)
 
-	docMap, present := index.Index[term]
+	docMap, present := terms[term]
 	if !present {
 		// new DocMap
-		index.Index[term] = make(DocMap)
-		docMap = index.Index[term]
+		terms[term] = make(index.DocMap)
+		docMap = terms[term]
 	}
 	_, present = docMap[path]
 	if !present {
 		//new docTerm
-		docMap[path] = &DocTerm{
+		docMap[path] = &index.DocTerm{
 			Term:      term,
             Pack:      pack,
 			Path:      path,
@@ -101,56 +80,451 @@ func updateIndex(term string, pack string, path string) *DocTerm {
 			Imports:   0,
 			Packages:  0,
 			Types:     0,
+			Comments:  0,
 		}
 	}
 	return docMap[path]
 }
 
-// walkFiles starts a goroutine to walk the directory tree at root and send the
-// path of each regular file on the string channel.  It sends the result of the
-// walk on the error channel.  If done is closed, walkFiles abandons its work.
-func walkDirs(done <-chan struct{}, root string) (<-chan string, <-chan error) {
-	dirs := make(chan string)
+// indexComment adds the analyzed words of a doc comment to terms
+func indexComment(terms index.IndexMap, doc *ast.CommentGroup, pack string, path string) {
+	for _, word := range analyzer.Analyze(doc.Text()) {
+		docTerm := updateIndex(terms, word, pack, path)
+		docTerm.Comments += 1
+	}
+}
+
+// A source is a dir of Go files to index. Dirs on disk only have dir and the
+// root they were walked from set; dirs read from an archive also carry their
+// files, import path, module and the stamp of the archive they came from.
+type source struct {
+	dir   string
+	root  string
+	files map[string][]byte //contents of each file in the dir, nil on disk
+	path  string
+	mod   *importpath.Module
+	stamp index.DirStamp
+}
+
+// walkDirs starts a goroutine to walk the directory trees and archives at
+// roots and send each dir on the source channel.  It sends the result of the
+// walk on the error channel.  If done is closed, walkDirs abandons its work.
+func walkDirs(done <-chan struct{}, roots []string) (<-chan source, <-chan error) {
+	dirs := make(chan source)
 	errc := make(chan error, 1)
+	send := func(s source) error {
+		select {
+		case dirs <- s:
+			return nil
+		case <-done:
+			return errors.New("walk canceled")
+		}
+	}
 	go func() { // HL
 		// Close the paths channel after Walk returns.
 		defer close(dirs) // HL
-		// No select needed for this send, since errc is buffered.
-		errc <- filepath.Walk(root, func(dir string, info os.FileInfo, err error) error { // HL
-			if err != nil {
-				return err
-			}
-			if !info.IsDir() {
-				return nil
+		for _, root := range roots {
+			var err error
+			if isArchive(root) {
+				err = readArchive(root, send)
+			} else {
+				err = filepath.Walk(root, func(dir string, info os.FileInfo, err error) error { // HL
+					if err != nil {
+						return err
+					}
+					if !info.IsDir() {
+						return nil
+					}
+					if info.Name() == "vendor" && dir != root {
+						return filepath.SkipDir
+					}
+					return send(source{dir: dir, root: root})
+				})
 			}
-			select {
-			case dirs <- dir:
-			case <-done:
-				return errors.New("walk canceled")
+			if err != nil {
+				// No select needed for this send, since errc is buffered.
+				errc <- err
+				return
 			}
-			return nil
-		})
+		}
+		errc <- nil
 	}()
 	return dirs, errc
 }
 
-// A result is the product of parsing a package into an AST
+// isArchive reports whether name is a module archive rather than a dir
+func isArchive(name string) bool {
+	for _, ext := range []string{".zip", ".tar", ".tar.gz", ".tgz"} {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// readArchive sends every dir of Go files in the archive name. Dirs are
+// stored under the import path their module gives them: archives laid out
+// like the module proxy's zips, module@version/..., carry it in their paths,
+// otherwise it is read from the nearest go.mod in the archive. Dirs outside
+// of any module are stored under their path in the archive. Vendored dirs
+// are skipped. Every dir is stamped with the archive, so they are all parsed
+// again when it changes.
+func readArchive(name string, send func(source) error) error {
+	info, err := os.Stat(name)
+	if err != nil {
+		return err
+	}
+	stamp := index.DirStamp{filepath.Base(name): {ModTime: info.ModTime().UnixNano(), Size: info.Size()}}
+	dirs, err := archiveFiles(name)
+	if err != nil {
+		return fmt.Errorf("%v: %v", name, err)
+	}
+	gomods := make(map[string][]byte)
+	for dir, files := range dirs {
+		if gomod, ok := files["go.mod"]; ok {
+			gomods[dir] = gomod
+			delete(files, "go.mod")
+		}
+	}
+	for dir, files := range dirs {
+		if len(files) == 0 {
+			continue
+		}
+		goPath, mod := importpath.ArchiveDir(dir, gomods)
+		s := source{dir: dir, files: files, path: goPath, mod: mod, stamp: stamp}
+		if err := send(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// archiveFiles reads the .go and go.mod files in a zip or (gzipped) tar
+// archive, keyed by their dir and name within it
+func archiveFiles(name string) (map[string]map[string][]byte, error) {
+	dirs := make(map[string]map[string][]byte)
+	add := func(name string, r io.Reader) error {
+		name = path.Clean(strings.TrimPrefix(name, "/"))
+		dir, file := path.Split(name)
+		if !strings.HasSuffix(file, ".go") && file != "go.mod" || importpath.Vendored(dir) {
+			return nil
+		}
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		dir = path.Clean(dir)
+		if dirs[dir] == nil {
+			dirs[dir] = make(map[string][]byte)
+		}
+		dirs[dir][file] = data
+		return nil
+	}
+
+	if strings.HasSuffix(name, ".zip") {
+		z, err := zip.OpenReader(name)
+		if err != nil {
+			return nil, err
+		}
+		defer z.Close()
+		for _, f := range z.File {
+			if f.FileInfo().IsDir() {
+				continue
+			}
+			r, err := f.Open()
+			if err != nil {
+				return nil, err
+			}
+			err = add(f.Name, r)
+			r.Close()
+			if err != nil {
+				return nil, err
+			}
+		}
+		return dirs, nil
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if !strings.HasSuffix(name, ".tar") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return dirs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if h.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := add(h.Name, tr); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// parseFiles parses the Go files of a dir read from an archive into packages
+// as parser.ParseDir does, filling src with their lines for the snippets.
+// Files are named by their path in the archive. It returns the first error.
+func parseFiles(fset *token.FileSet, dir string, files map[string][]byte, src srcCache) (map[string]*ast.Package, error) {
+	pkgs := make(map[string]*ast.Package)
+	var first error
+	for name, data := range files {
+		filename := path.Join(dir, name)
+		f, err := parser.ParseFile(fset, filename, data, parser.ParseComments)
+		if err != nil {
+			if first == nil {
+				first = err
+			}
+			continue
+		}
+		src[filename] = strings.Split(string(data), "\n")
+		pkg, ok := pkgs[f.Name.Name]
+		if !ok {
+			pkg = &ast.Package{Name: f.Name.Name, Files: make(map[string]*ast.File)}
+			pkgs[f.Name.Name] = pkg
+		}
+		pkg.Files[filename] = f
+	}
+	return pkgs, first
+}
+
+// A result reports what a dirParser made of a dir
 type result struct {
-	pkgs   map[string]*ast.Package
 	prefix string
-	err    error
+	path   string //import path of the dir
+	stamp  index.DirStamp
+	//set when the dir matches its stamp in the previous index and was not parsed
+	unchanged bool
+	err       error
 }
 
-// digester reads path names from paths and sends digests of the corresponding
-// files on c until either paths or done is closed.
-func dirParser(done <-chan struct{}, dirs <-chan string, c chan<- result) {
-	for dir := range dirs {
-		fset := token.NewFileSet()
-		//fmt.Println("Parseing: ", dir)
-		pkgs, err := parser.ParseDir(fset, dir, nil, parser.ParseComments)
+// stampDir records the modification time and size of every .go file in dir
+func stampDir(dir string) (index.DirStamp, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	stamp := make(index.DirStamp)
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".go") {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return nil, err
+		}
+		stamp[e.Name()] = index.FileStamp{ModTime: info.ModTime().UnixNano(), Size: info.Size()}
+	}
+	return stamp, nil
+}
+
+// fileOrigins tells the files of the parsed pkgs that are not production code
+// apart, mapping their names to index.OriginIgnored if buildContext excludes
+// them and to index.OriginTest if they are tests. Production files are left
+// out.
+func fileOrigins(s source, pkgs map[string]*ast.Package) map[string]string {
+	ctxt := buildContext
+	if s.files != nil {
+		ctxt.OpenFile = func(name string) (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(s.files[path.Base(name)])), nil
+		}
+	}
+	origins := make(map[string]string)
+	for _, pkg := range pkgs {
+		for filename := range pkg.Files {
+			name := filepath.Base(filename)
+			match, err := ctxt.MatchFile(s.dir, name)
+			switch {
+			case err != nil || !match:
+				origins[filename] = index.OriginIgnored
+			case strings.HasSuffix(name, "_test.go"):
+				origins[filename] = index.OriginTest
+			}
+		}
+	}
+	return origins
+}
+
+// pkgOrigin is the origin of a package as a whole: production code if any of
+// its files are, else a test if any of them are
+func pkgOrigin(pkg *ast.Package, origins map[string]string) string {
+	origin := index.OriginIgnored
+	for filename := range pkg.Files {
+		switch origins[filename] {
+		case "":
+			return ""
+		case index.OriginTest:
+			origin = index.OriginTest
+		}
+	}
+	return origin
+}
+
+// checkTypes type checks the production code of pkgs, recording the method
+// sets of the named types it declares in part, along with those of the
+// exported interfaces of the packages it imports. Type errors, such as
+// imports that can't be found, are ignored: what can be resolved is still
+// recorded, and methods whose signatures can't be never match.
+func checkTypes(part *index.Index, fset *token.FileSet, imp types.Importer, path string, pkgs map[string]*ast.Package, origins map[string]string) {
+	for _, pkg := range pkgs {
+		var files []*ast.File
+		for filename, f := range pkg.Files {
+			if origins[filename] == "" {
+				files = append(files, f)
+			}
+		}
+		if len(files) == 0 {
+			continue
+		}
+		sort.Slice(files, func(i, j int) bool { return fset.Position(files[i].Pos()).Filename < fset.Position(files[j].Pos()).Filename })
+		conf := types.Config{
+			Importer:    imp,
+			Sizes:       types.SizesFor("gc", *goarch),
+			FakeImportC: true,
+			Error:       func(error) {},
+		}
+		tpkg, _ := conf.Check(path, fset, files, nil)
+		addTypes(part, tpkg, false)
+		for _, dep := range tpkg.Imports() {
+			addTypes(part, dep, true)
+		}
+	}
+}
+
+// addTypes records the named types declared by pkg in part, or only its
+// exported interfaces. Generic types are left out, as their method sets
+// depend on how they are instantiated.
+func addTypes(part *index.Index, pkg *types.Package, interfacesOnly bool) {
+	scope := pkg.Scope()
+	for _, name := range scope.Names() {
+		tn, ok := scope.Lookup(name).(*types.TypeName)
+		if !ok || tn.IsAlias() {
+			continue
+		}
+		named, ok := tn.Type().(*types.Named)
+		if !ok || named.TypeParams().Len() > 0 {
+			continue
+		}
+		_, isInterface := named.Underlying().(*types.Interface)
+		if interfacesOnly && (!isInterface || !tn.Exported()) {
+			continue
+		}
+		t := &index.NamedType{Name: name, Path: pkg.Path(), Interface: isInterface, Methods: methodSet(named)}
+		part.Types[t.ID()] = t
+	}
+}
+
+// methodSet lists the methods of *t, or of t if it is an interface, sorted by
+// name
+func methodSet(t *types.Named) []index.Method {
+	value := types.NewMethodSet(t)
+	all := value
+	if !types.IsInterface(t) {
+		all = types.NewMethodSet(types.NewPointer(t))
+	}
+	methods := make([]index.Method, 0, all.Len())
+	for i := 0; i < all.Len(); i++ {
+		f := all.At(i).Obj().(*types.Func)
+		m := index.Method{Name: f.Name(), Signature: typeSignature(f.Type().(*types.Signature))}
+		if strings.Contains(m.Signature, "invalid type") {
+			//unique to this method, so that it matches no other
+			m.Signature = "unresolved " + f.FullName()
+		}
+		if !f.Exported() && f.Pkg() != nil {
+			m.Name = f.Pkg().Path() + "." + f.Name()
+		}
+		m.Pointer = value.Lookup(f.Pkg(), f.Name()) == nil
+		methods = append(methods, m)
+	}
+	sort.Slice(methods, func(i, j int) bool { return methods[i].Name < methods[j].Name })
+	return methods
+}
+
+// typeSignature formats the parameter and result types of a signature without
+// their names and with package paths in full, so that two methods match if
+// their signatures format the same
+func typeSignature(sig *types.Signature) string {
+	qualifier := func(p *types.Package) string { return p.Path() }
+	tuple := func(t *types.Tuple, variadic bool) string {
+		parts := make([]string, t.Len())
+		for i := range parts {
+			typ := t.At(i).Type()
+			if s, ok := typ.(*types.Slice); ok && variadic && i == len(parts)-1 {
+				parts[i] = "..." + types.TypeString(s.Elem(), qualifier)
+			} else {
+				parts[i] = types.TypeString(typ, qualifier)
+			}
+		}
+		return "(" + strings.Join(parts, ", ") + ")"
+	}
+	return tuple(sig.Params(), sig.Variadic()) + " " + tuple(sig.Results(), false)
+}
+
+// dirParser reads dirs from dirs, parses the packages in each and indexes
+// them into its own partial index, part, sending a result for each dir on c
+// until either dirs or done is closed. Dirs whose stamp matches the one in
+// prev are passed through without being parsed.
+func dirParser(done <-chan struct{}, dirs <-chan source, prev map[string]index.DirStamp, part *index.Index, c chan<- result) {
+	//type checks imports from source, caching them for the dirs to come
+	var imp types.Importer
+	if *typeCheck {
+		imp = importer.ForCompiler(token.NewFileSet(), "source", nil)
+	}
+	for s := range dirs {
+		r := result{prefix: s.dir}
+		goPath, mod := s.path, s.mod
+		if s.files == nil {
+			goPath, mod = importpath.Dir(s.dir, s.root)
+			r.stamp, r.err = stampDir(s.dir)
+		} else {
+			r.stamp = s.stamp
+		}
+		r.path = goPath
+		if r.err == nil {
+			if old, ok := prev[goPath]; ok && old.Equal(r.stamp) {
+				r.unchanged = true
+			} else if len(r.stamp) > 0 {
+				fset := token.NewFileSet()
+				src := make(srcCache)
+				//fmt.Println("Parseing: ", dir)
+				var pkgs map[string]*ast.Package
+				if s.files == nil {
+					pkgs, r.err = parser.ParseDir(fset, s.dir, nil, parser.ParseComments)
+				} else {
+					pkgs, r.err = parseFiles(fset, s.dir, s.files, src)
+				}
+				if r.err == nil {
+					part.Dirs[goPath] = r.stamp
+					origins := fileOrigins(s, pkgs)
+					if err := indexPackages(part, fset, pkgs, goPath, src, origins); err != nil {
+						log.Println("In AST Parser:", err)
+					}
+					if imp != nil {
+						checkTypes(part, fset, imp, goPath, pkgs, origins)
+					}
+					if p, ok := part.Packages[goPath]; ok && mod != nil {
+						p.Module, p.Version = mod.Path, mod.Version
+					}
+				}
+			}
+		}
 
 		select {
-		case c <- result{pkgs, dir, err}:
+		case c <- r:
 		case <-done:
 			return
 		}
@@ -161,82 +535,503 @@ func dirParser(done <-chan struct{}, dirs <-chan string, c chan<- result) {
 // from file path to the MD5 sum of the file's contents.  If the directory walk
 // fails or any read operation fails, MD5All returns an error.  In that case,
 // MD5All does not wait for inflight read operations to complete.
-func indexer(root string) error {
+//
+// Dirs recorded in idx.Dirs that have not changed since are skipped; changed
+// dirs have their old postings removed from idx, and dirs that have
+// disappeared are removed from the index. Each parser indexes the dirs it
+// parses into a partial index of its own, which are returned to be merged
+// into idx or written out as shards.
+func indexer(roots []string) ([]*index.Index, error) {
 	// MD5All closes the done channel when it returns; it may do so before
 	// receiving all the values from c and errc.
 	done := make(chan struct{})
 	defer close(done)
 
-	dirs, errc := walkDirs(done, root)
+	//the parsers only read prev, the loop below builds the new stamps. idx
+	//is left alone until the walk has succeeded, so a failed run can't leave
+	//stamps behind for postings it never wrote, or the other way round.
+	prev := idx.Dirs
+	stamps := make(map[string]index.DirStamp)
+	replaced := make(map[string]struct{})
+
+	dirs, errc := walkDirs(done, roots)
 
 	// Start a fixed number of goroutines to read and digest files.
 	c := make(chan result) // HLc
+	parts := make([]*index.Index, numDirParsers)
 	var wg sync.WaitGroup
 	wg.Add(numDirParsers)
 	for i := 0; i < numDirParsers; i++ {
-		go func() {
-			dirParser(done, dirs, c) // HLc
+		parts[i] = index.New()
+		parts[i].Analysis, parts[i].Constraints, parts[i].TypeChecked = idx.Analysis, idx.Constraints, idx.TypeChecked
+		go func(part *index.Index) {
+			dirParser(done, dirs, prev, part, c) // HLc
 			wg.Done()
-		}()
+		}(parts[i])
 	}
 	go func() {
 		wg.Wait()
 		close(c) // HLc
 	}()
 
+	reparsed := 0
 	for r := range c {
+		goPath := r.path
 		if r.err != nil {
 			//log.Println("In DirParser:", r.err)
-			//return
+			//keep the old postings and try again next run
+			if old, ok := prev[goPath]; ok {
+				stamps[goPath] = old
+			}
 			continue
 		}
-        
-        absPath, _ := filepath.Abs(r.prefix)
-        goPath := strings.TrimPrefix(absPath, "/home/ubuntu/")
-		err := indexPackages(r.pkgs, goPath)
-		if err != nil {
-			log.Println("In AST Parser:", err)
+		if r.unchanged {
+			stamps[goPath] = r.stamp
+			continue
+		}
+		replaced[goPath] = struct{}{}
+		if len(r.stamp) == 0 {
+			continue
 		}
+
+		stamps[goPath] = r.stamp
+		reparsed++
 	}
 	// Check whether the Walk failed.
 	if err := <-errc; err != nil { // HLerrc
 		log.Println("In Walk:")
-		return err
+		return nil, err
+	}
+
+	//anything left in prev that wasn't seen on this walk has been deleted
+	deleted := 0
+	for path := range prev {
+		if _, ok := stamps[path]; !ok {
+			replaced[path] = struct{}{}
+			deleted++
+		}
+	}
+	//the postings of every package parsed again replace the old ones, even
+	//if the old stamps didn't know about them
+	for _, part := range parts {
+		for path := range part.Packages {
+			replaced[path] = struct{}{}
+		}
+	}
+	idx.RemovePaths(replaced)
+	idx.Dirs = stamps
+	log.Printf("Parsed %v changed packages, removed %v deleted packages", reparsed, deleted)
+	return parts, nil
+}
+
+// writeShards merges the partial indexes into n shards, each holding a
+// disjoint set of packages, and writes them next to the index file
+func writeShards(parts []*index.Index, n int) error {
+	shards := make([]*index.Index, n)
+	for k := range shards {
+		shards[k] = index.New()
+		shards[k].Analysis, shards[k].Constraints, shards[k].TypeChecked = idx.Analysis, idx.Constraints, idx.TypeChecked
+	}
+	//packages are spread by path, whichever worker happened to parse them
+	for _, part := range parts {
+		for k, sub := range part.Split(n) {
+			if err := shards[k].Merge(sub); err != nil {
+				return err
+			}
+		}
+	}
+	//static ranks need the whole import graph, which no one shard has
+	all := make(map[string]*index.Package)
+	for _, shard := range shards {
+		for path, pkg := range shard.Packages {
+			all[path] = pkg
+		}
+	}
+	ranks := index.PageRank(all)
+	for _, shard := range shards {
+		shard.Ranks = make(map[string]float64, len(shard.Packages))
+		for path := range shard.Packages {
+			shard.Ranks[path] = ranks[path]
+		}
+	}
+	for k, shard := range shards {
+		name := index.ShardFile(indexFile, k)
+		log.Printf("Writing shard %v with %v packages to %v", k, shard.UniquePkgs, name)
+		if err := shard.Save(name); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
-func tokenizeCamelCase(str string) []string {
-	var words []string
-	l := 0
-	for s := str; s != ""; s = s[l:] {
-		l = strings.IndexFunc(s[1:], unicode.IsUpper) + 1
-		if l <= 0 {
-			l = len(s)
+// A srcCache holds the lines of the source files of a package, read as the
+// first declaration in each file is indexed
+type srcCache map[string][]string
+
+// loc describes the declaration of kind and name at pos
+func (s srcCache) loc(fset *token.FileSet, kind, name string, pos token.Pos) index.Loc {
+	p := fset.Position(pos)
+	lines, ok := s[p.Filename]
+	if !ok {
+		src, err := os.ReadFile(p.Filename)
+		if err == nil {
+			lines = strings.Split(string(src), "\n")
+		}
+		s[p.Filename] = lines
+	}
+	l := index.Loc{Kind: kind, Name: name, File: filepath.Base(p.Filename), Line: p.Line}
+	if p.Line > 0 && p.Line <= len(lines) {
+		l.Snippet = strings.TrimSpace(lines[p.Line-1])
+		if len(l.Snippet) > maxSnippet {
+			l.Snippet = strings.ToValidUTF8(l.Snippet[:maxSnippet], "")
 		}
-		words = append(words, s[:l])
 	}
+	return l
+}
+
+const maxSnippet = 120
 
-	return words
+// recvType returns the name of the type a method's receiver is declared as
+func recvType(expr ast.Expr) string {
+	switch x := expr.(type) {
+	case *ast.StarExpr:
+		return recvType(x.X)
+	case *ast.IndexExpr:
+		return recvType(x.X)
+	case *ast.IndexListExpr:
+		return recvType(x.X)
+	case *ast.Ident:
+		return x.Name
+	}
+	return ""
+}
+
+// signature renders a declaration without its body or doc comment. Constants
+// and variables are passed as a GenDecl holding just their spec, fields and
+// interface methods as a Field with just their name.
+func signature(fset *token.FileSet, decl ast.Node) string {
+	switch x := decl.(type) {
+	case *ast.FuncDecl:
+		decl = &ast.FuncDecl{Recv: x.Recv, Name: x.Name, Type: x.Type}
+	case *ast.TypeSpec:
+		spec := *x
+		spec.Doc, spec.Comment = nil, nil
+		switch spec.Type.(type) {
+		case *ast.StructType:
+			return "type " + x.Name.Name + " struct{...}"
+		case *ast.InterfaceType:
+			return "type " + x.Name.Name + " interface{...}"
+		}
+		decl = &ast.GenDecl{Tok: token.TYPE, Specs: []ast.Spec{&spec}}
+	case *ast.GenDecl:
+		spec := *x.Specs[0].(*ast.ValueSpec)
+		spec.Doc, spec.Comment = nil, nil
+		if x.Tok == token.VAR && spec.Type != nil {
+			spec.Values = nil
+		}
+		//long values are cut at the end of their first line
+		var buf bytes.Buffer
+		printer.Fprint(&buf, fset, &ast.GenDecl{Tok: x.Tok, Specs: []ast.Spec{&spec}})
+		sig := buf.String()
+		if i := strings.IndexByte(sig, '\n'); i >= 0 {
+			sig = sig[:i] + " ..."
+		}
+		return sig
+	case *ast.Field:
+		name := x.Names[0].Name
+		switch t := x.Type.(type) {
+		case *ast.FuncType:
+			return name + strings.TrimPrefix(signature(fset, t), "func")
+		case *ast.StructType:
+			return name + " struct{...}"
+		case *ast.InterfaceType:
+			return name + " interface{...}"
+		}
+		return name + " " + signature(fset, x.Type)
+	}
+	var buf bytes.Buffer
+	printer.Fprint(&buf, fset, decl)
+	return buf.String()
+}
+
+// indexSymbols records the exported package level functions, methods, types,
+// constants and variables of pkg, along with the exported fields and
+// interface methods of its exported types, as symbols in part, tagged with
+// the origin of their file
+func indexSymbols(part *index.Index, fset *token.FileSet, pkg *ast.Package, pack string, path string, origins map[string]string) {
+	for filename, file := range pkg.Files {
+		origin := origins[filename]
+		imports := shape.Imports(file)
+		for _, decl := range file.Decls {
+			switch x := decl.(type) {
+			case *ast.FuncDecl:
+				if !x.Name.IsExported() {
+					continue
+				}
+				//examples are attached to what they document by indexExamples
+				if origin == index.OriginTest && strings.HasPrefix(x.Name.Name, "Example") && x.Recv == nil {
+					continue
+				}
+				sig := shape.Of(x, pack, imports)
+				sym := &index.Symbol{Name: x.Name.Name, Kind: index.KindFunc, Origin: origin,
+					Params: sig.Params, Results: sig.Results, TypeParams: sig.TypeParams}
+				if x.Recv != nil && len(x.Recv.List) > 0 {
+					sym.Recv = recvType(x.Recv.List[0].Type)
+					sym.Kind = index.KindMethod
+					if !ast.IsExported(sym.Recv) {
+						continue
+					}
+				}
+				addSymbol(part, fset, sym, x, x.Pos(), pack, path)
+
+			case *ast.GenDecl:
+				switch x.Tok {
+				case token.TYPE:
+					for _, spec := range x.Specs {
+						ts := spec.(*ast.TypeSpec)
+						if !ts.Name.IsExported() {
+							continue
+						}
+						sym := &index.Symbol{Name: ts.Name.Name, Kind: index.KindType, Origin: origin}
+						addSymbol(part, fset, sym, ts, ts.Pos(), pack, path)
+						switch t := ts.Type.(type) {
+						case *ast.StructType:
+							addMembers(part, fset, ts.Name.Name, t.Fields, index.KindField, pack, path, origin)
+						case *ast.InterfaceType:
+							addMembers(part, fset, ts.Name.Name, t.Methods, index.KindInterfaceMethod, pack, path, origin)
+						}
+					}
+				case token.CONST, token.VAR:
+					kind := index.KindConst
+					if x.Tok == token.VAR {
+						kind = index.KindVar
+					}
+					for _, spec := range x.Specs {
+						vs := spec.(*ast.ValueSpec)
+						for _, id := range vs.Names {
+							if !id.IsExported() {
+								continue
+							}
+							sym := &index.Symbol{Name: id.Name, Kind: kind, Origin: origin}
+							decl := &ast.GenDecl{Tok: x.Tok, Specs: []ast.Spec{vs}}
+							addSymbol(part, fset, sym, decl, id.Pos(), pack, path)
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+// addMembers records the exported fields of a struct or methods of an
+// interface named typeName as symbols of kind in part. Embedded fields and
+// interfaces have no name of their own and are left out.
+func addMembers(part *index.Index, fset *token.FileSet, typeName string, list *ast.FieldList, kind string, pack string, path string, origin string) {
+	for _, f := range list.List {
+		for _, id := range f.Names {
+			if !id.IsExported() {
+				continue
+			}
+			sym := &index.Symbol{Name: id.Name, Recv: typeName, Kind: kind, Origin: origin}
+			member := &ast.Field{Names: []*ast.Ident{id}, Type: f.Type}
+			addSymbol(part, fset, sym, member, id.Pos(), pack, path)
+		}
+	}
+}
+
+func addSymbol(part *index.Index, fset *token.FileSet, sym *index.Symbol, decl ast.Node, pos token.Pos, pack string, path string) {
+	p := fset.Position(pos)
+	sym.Pack = pack
+	sym.Path = path
+	sym.Signature = signature(fset, decl)
+	sym.File = filepath.Base(p.Filename)
+	sym.Line = p.Line
+	part.AddSymbol(sym)
+}
+
+// indexExamples attaches the Example functions in the test files of pkgs to
+// the symbols of part they document. Examples of the package as a whole, or
+// of symbols that weren't indexed, are attached to the package at path.
+func indexExamples(part *index.Index, fset *token.FileSet, pkgs map[string]*ast.Package, path string, origins map[string]string) {
+	var files []*ast.File
+	for _, pkg := range pkgs {
+		for filename, f := range pkg.Files {
+			if origins[filename] == index.OriginTest {
+				files = append(files, f)
+			}
+		}
+	}
+	for _, ex := range doc.Examples(files...) {
+		p := fset.Position(ex.Code.Pos())
+		e := index.Example{
+			Name:   ex.Name,
+			Doc:    ex.Doc,
+			Code:   exampleCode(fset, ex),
+			Output: ex.Output,
+			File:   filepath.Base(p.Filename),
+			Line:   p.Line,
+		}
+		if sym, ok := part.Symbols[exampleID(path, ex.Name)]; ok {
+			sym.Examples = append(sym.Examples, e)
+		} else if pkg, ok := part.Packages[path]; ok {
+			pkg.Examples = append(pkg.Examples, e)
+		}
+	}
+}
+
+// exampleID is the ID of the symbol in the package at path that an example
+// named name documents: ExampleF documents F and ExampleT_M the method T.M,
+// either of which may be followed by a _suffix starting with a lower case
+// letter. Package examples have no ID.
+func exampleID(path, name string) string {
+	if i := strings.LastIndex(name, "_"); i >= 0 && i+1 < len(name) && unicode.IsLower(rune(name[i+1])) {
+		name = name[:i]
+	}
+	if name == "" {
+		return ""
+	}
+	return path + "." + strings.Replace(name, "_", ".", 1)
+}
+
+// outputComment starts the output an example is checked against
+var outputComment = regexp.MustCompile(`(?i)^//\s*(unordered )?output:`)
+
+// exampleCode prints the body of an example, with its comments but without
+// its braces or the output comment that ends it
+func exampleCode(fset *token.FileSet, ex *doc.Example) string {
+	var buf bytes.Buffer
+	printer.Fprint(&buf, fset, &printer.CommentedNode{Node: ex.Code, Comments: ex.Comments})
+	code := strings.TrimSuffix(strings.TrimPrefix(buf.String(), "{"), "}")
+	var lines []string
+	for _, l := range strings.Split(strings.Trim(code, "\n"), "\n") {
+		if outputComment.MatchString(strings.TrimSpace(l)) {
+			break
+		}
+		lines = append(lines, strings.TrimPrefix(l, "\t"))
+	}
+	return strings.TrimRight(strings.Join(lines, "\n"), "\n")
 }
 
 // This is a long function definitions spanning multiple
 // lines and all relates to a single comment related to a single
 // function
-func indexPackages(pkgs map[string]*ast.Package, prefix string) error {
-	for name, pkg := range pkgs {
+func indexPackages(part *index.Index, fset *token.FileSet, pkgs map[string]*ast.Package, prefix string, src srcCache, origins map[string]string) error {
+	//production packages first, so the package is named after one of them
+	names := make([]string, 0, len(pkgs))
+	for name := range pkgs {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		pi, pj := pkgOrigin(pkgs[names[i]], origins) == "", pkgOrigin(pkgs[names[j]], origins) == ""
+		if pi != pj {
+			return pi
+		}
+		return names[i] < names[j]
+	})
+
+	for _, name := range names {
+		pkg := pkgs[name]
 		path := prefix
         pack := name
+		info := part.Package(pack, path)
 		//fmt.Println("Inspecting ", path)
 
+		//the file being inspected, and where its postings go
+		origin, terms := "", part.Index
+		setOrigin := func(o string) {
+			origin, terms = o, part.Index
+			if o != "" {
+				terms = part.Other
+			}
+		}
+		locate := func(kind, name string, pos token.Pos) index.Loc {
+			l := src.loc(fset, kind, name, pos)
+			l.Origin = origin
+			return l
+		}
+		//addName indexes the words of a name, counting each with count
+		addName := func(name string, loc index.Loc, count func(*index.DocTerm)) {
+			for _, n := range tokenizer.Words(name) {
+				docTerm := updateIndex(terms, n, pack, path)
+				count(docTerm)
+				docTerm.AddLoc(loc)
+			}
+		}
+
+		//indexMembers indexes the fields of a struct or the methods of an
+		//interface, both by name and as Type.Name
+		indexMembers := func(typeName string, list *ast.FieldList, kind string) {
+			count := func(d *index.DocTerm) { d.InterfaceMethods += 1 }
+			if kind == index.KindField {
+				count = func(d *index.DocTerm) { d.Fields += 1 }
+			}
+			for _, f := range list.List {
+				//embedded fields and interfaces have no names
+				for _, id := range f.Names {
+					if id.Name == "_" {
+						continue
+					}
+					name := typeName + "." + id.Name
+					loc := locate(kind, name, id.Pos())
+					addName(id.Name, loc, count)
+					docTerm := updateIndex(terms, name, pack, path)
+					count(docTerm)
+					docTerm.AddLoc(loc)
+				}
+				if f.Doc != nil && *commentParse {
+					indexComment(terms, f.Doc, pack, path)
+				}
+			}
+		}
+
+		//indexValues indexes the names of package level constants or
+		//variables
+		indexValues := func(decl *ast.GenDecl) {
+			kind, count := index.KindConst, func(d *index.DocTerm) { d.Consts += 1 }
+			if decl.Tok == token.VAR {
+				kind, count = index.KindVar, func(d *index.DocTerm) { d.Vars += 1 }
+			}
+			if decl.Doc != nil && *commentParse {
+				indexComment(terms, decl.Doc, pack, path)
+			}
+			for _, spec := range decl.Specs {
+				vs := spec.(*ast.ValueSpec)
+				for _, id := range vs.Names {
+					if id.Name != "_" {
+						addName(id.Name, locate(kind, id.Name, id.Pos()), count)
+					}
+				}
+				if vs.Doc != nil && *commentParse {
+					indexComment(terms, vs.Doc, pack, path)
+				}
+			}
+		}
+
 		ast.Inspect(pkg, func(n ast.Node) bool {
 
 			switch x := n.(type) {
+			//Package docs
+			case *ast.File:
+				setOrigin(origins[fset.Position(x.Package).Filename])
+				if x.Doc != nil && *commentParse {
+					indexComment(terms, x.Doc, pack, path)
+				}
+				if x.Doc != nil && origin == "" && info.Synopsis == "" {
+					info.Synopsis = new(doc.Package).Synopsis(x.Doc.Text())
+				}
+				//Constants and variables, at package level only
+				for _, decl := range x.Decls {
+					if gd, ok := decl.(*ast.GenDecl); ok && (gd.Tok == token.CONST || gd.Tok == token.VAR) {
+						indexValues(gd)
+					}
+				}
+
 			//Packages
 			case *ast.Package:
 				if x.Name != "" {
+					setOrigin(pkgOrigin(x, origins))
 					//update index and docMap if necessary
-					docTerm := updateIndex(x.Name, pack, path)
+					docTerm := updateIndex(terms, x.Name, pack, path)
 					//update docTerm
 					docTerm.Packages += 1
 				}
@@ -245,77 +1040,91 @@ func indexPackages(pkgs map[string]*ast.Package, prefix string) error {
 			//Imports
 			case *ast.ImportSpec:
 				if x.Path.Value != "" {
+					importPath := strings.Replace(x.Path.Value, "\"", "", -1)
 					//update index and docMap if necessary
-					docTerm := updateIndex(strings.Replace(x.Path.Value, "\"", "", -1), pack, path)
+					docTerm := updateIndex(terms, importPath, pack, path)
 					//update docTerm
 					docTerm.Imports += 1
+					docTerm.AddLoc(locate(index.KindImport, importPath, x.Pos()))
+					//only production code adds to the import graph
+					if origin == "" {
+						info.AddImport(importPath)
+					}
 				}
 				break
 
 			//Functions
 			case *ast.FuncDecl:
 				if x.Name.Name != "" {
+					kind, name := index.KindFunc, x.Name.Name
+					recv := ""
+					if x.Recv != nil && len(x.Recv.List) > 0 {
+						//Methods are recorded against their receiver type,
+						//both by name and as Type.Method
+						kind = index.KindMethod
+						if recv = recvType(x.Recv.List[0].Type); recv != "" {
+							name = recv + "." + x.Name.Name
+						}
+					}
+					loc := locate(kind, name, x.Pos())
 					//Name tokenize function
-					for _, n := range tokenizeCamelCase(x.Name.Name) {
+					for _, n := range tokenizer.Words(x.Name.Name) {
 						//update index and docMap if necessary
-						docTerm := updateIndex(n, pack, path)
+						docTerm := updateIndex(terms, n, pack, path)
 						//update docTerm
-						docTerm.Functions += 1
+						if kind == index.KindMethod {
+							docTerm.Methods += 1
+						} else {
+							docTerm.Functions += 1
+						}
+						docTerm.AddLoc(loc)
+					}
+					if recv != "" {
+						docTerm := updateIndex(terms, name, pack, path)
+						docTerm.Methods += 1
+						docTerm.AddLoc(loc)
 					}
 
 					//Add comments to index
 					if x.Doc != nil && *commentParse {
-						comment := ""
-						for _, c := range x.Doc.List {
-							comment += c.Text
-						}
-
-						comment = strings.Replace(comment, "//", "", -1)
-						comment = strings.ToLower(comment)
-
-						words := strings.Fields(comment)
-
-						for _, word := range words {
-							docTerm := updateIndex(word, pack, path)
-							docTerm.Functions += 1
-						}
+						indexComment(terms, x.Doc, pack, path)
 					}
 				}
 				break
 
 			case *ast.TypeSpec:
 				if x.Name.Name != "" {
+					loc := locate(index.KindType, x.Name.Name, x.Pos())
 					//Name tokenize function
-					for _, n := range tokenizeCamelCase(x.Name.Name) {
+					for _, n := range tokenizer.Words(x.Name.Name) {
 						//update index and docMap if necessary
-						docTerm := updateIndex(n, pack, path)
+						docTerm := updateIndex(terms, n, pack, path)
 						//update docTerm
 						docTerm.Types += 1
+						docTerm.AddLoc(loc)
+					}
+
+					//Struct fields and interface methods
+					switch t := x.Type.(type) {
+					case *ast.StructType:
+						indexMembers(x.Name.Name, t.Fields, index.KindField)
+					case *ast.InterfaceType:
+						indexMembers(x.Name.Name, t.Methods, index.KindInterfaceMethod)
 					}
 
 					//Add comments to index
 					if x.Doc != nil && *commentParse {
-						comment := ""
-						for _, c := range x.Doc.List {
-							comment += c.Text
-						}
-
-						comment = strings.Replace(comment, "//", "", -1)
-						comment = strings.ToLower(comment)
-
-						words := strings.Fields(comment)
-
-						for _, word := range words {
-							docTerm := updateIndex(word, pack, path)
-							docTerm.Types += 1
-						}
+						indexComment(terms, x.Doc, pack, path)
 					}
 				}
 				break
 			}
 			return true
 		})
+
+		indexSymbols(part, fset, pkg, pack, path, origins)
 	}
+	indexExamples(part, fset, pkgs, prefix, origins)
 
 	return nil
 }
@@ -327,26 +1136,79 @@ func main() {
 	}
 	t0 := time.Now()
 
-	log.Println(*inputPath)
-	err := indexer(*inputPath)
-	if err != nil {
-		log.Println(err)
+	config := analysis.DefaultConfig
+	config.Stem, config.StopWords = *stem, *stopWords
+	analyzer = analysis.New(config)
+	idx.Analysis = config
+
+	buildContext = build.Default
+	buildContext.GOOS, buildContext.GOARCH = *goos, *goarch
+	if *buildTags != "" {
+		buildContext.BuildTags = strings.Split(*buildTags, ",")
 	}
-	//Count the number of packages
-	results := make(map[string]struct{}, 0)
-	for _, docMap := range index.Index {
-		for path, _ := range docMap {
-			results[path] = struct{}{}
+	idx.Constraints = index.Constraints{GOOS: *goos, GOARCH: *goarch, Tags: *buildTags}
+	idx.TypeChecked = *typeCheck
+
+	if *incremental && *shards > 0 {
+		log.Fatal("-incr updates a single index file and can't be combined with -shards")
+	}
+	if *incremental {
+		old, _, err := index.Open(indexFile)
+		switch {
+		case os.IsNotExist(err):
+			log.Println("No existing index, building from scratch")
+		case err != nil:
+			//a stale or corrupt index can't be trusted to tell us what changed
+			log.Printf("Can't update existing index (%v), building from scratch", err)
+		case old.Analysis != config:
+			log.Println("Comment analysis settings changed, building from scratch")
+		case old.Constraints != idx.Constraints:
+			log.Println("Build constraints changed, building from scratch")
+		case old.TypeChecked != *typeCheck:
+			log.Println("Type checking turned on or off, building from scratch")
+		default:
+			idx = old
 		}
 	}
-	index.UniquePkgs = len(results)
 
+	roots := flag.Args()
+	if *inputPath != "" {
+		roots = append([]string{*inputPath}, roots...)
+	}
+	log.Println(roots)
+	parts, err := indexer(roots)
+	if err != nil {
+		//a partial walk would lose or duplicate postings, keep the old index
+		log.Fatal(err)
+	}
 	t1 := time.Now()
-	log.Printf("Indexed %v unique terms in %v packages in %v:", len(index.Index), len(results), t1.Sub(t0))
+	log.Printf("Parsed and indexed in %v", t1.Sub(t0))
+
+	if *shards > 0 {
+		if err := writeShards(parts, *shards); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Wrote %v shards in %v", *shards, time.Since(t1))
+		return
+	}
+
+	for _, part := range parts {
+		if err := idx.Merge(part); err != nil {
+			log.Fatal(err)
+		}
+	}
+	//Count the number of packages
+	stats := idx.Stats()
+	idx.UniquePkgs = stats.Packages
+
+	log.Printf("Indexed %v unique terms in %v packages in %v:", stats.Terms, stats.Packages, time.Since(t0))
 
 	//Save index to file
 	t0 = time.Now()
-	index.Save()
+	log.Printf("Serializing index of size %v to file", stats.Terms)
+	if err := idx.Save(indexFile); err != nil {
+		log.Fatal(err)
+	}
 	t1 = time.Now()
 	log.Printf("Wrote index file in %v", t1.Sub(t0))
 
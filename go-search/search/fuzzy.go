@@ -0,0 +1,238 @@
+package search
+
+import (
+	"math"
+	"sort"
+	"strings"
+
+	"go-search/index"
+)
+
+// Misspelled query words are matched against the term dictionary through a
+// trigram index: a single edit changes at most four of a word's trigrams, so a
+// word within n edits of a term shares all but at most 4n of its trigrams
+// with it, and only terms sharing enough trigrams have their edit distance
+// computed.
+
+const (
+	//close terms are scored at fuzzyWeight to the power of their distance
+	fuzzyWeight = 0.5
+	//most close terms a misspelled word expands to
+	maxExpansions = 5
+	//a corrected query is suggested when its best result scores at least
+	//this many times the best result of the query as typed
+	suggestRatio = 1.5
+)
+
+// A closeTerm is an indexed term within a few edits of a query word
+type closeTerm struct {
+	term string
+	dist int
+	df   int
+}
+
+// trigrams maps each trigram of the terms of i, padded with '$' at both ends,
+// to the terms containing it
+func trigrams(i *index.Index) map[string][]string {
+	grams := make(map[string][]string)
+	for term := range i.Index {
+		for _, g := range termGrams(term) {
+			grams[g] = append(grams[g], term)
+		}
+	}
+	return grams
+}
+
+// termGrams returns the distinct trigrams of a term
+func termGrams(term string) []string {
+	padded := []rune("$" + term + "$")
+	seen := make(map[string]bool)
+	var grams []string
+	for i := 0; i+3 <= len(padded); i++ {
+		g := string(padded[i : i+3])
+		if !seen[g] {
+			seen[g] = true
+			grams = append(grams, g)
+		}
+	}
+	return grams
+}
+
+// maxEdits is how many edits a word of n runes may be away from a term it
+// expands to. Short words are too easily confused to correct at all.
+func maxEdits(n int) int {
+	switch {
+	case n <= 3:
+		return 0
+	case n <= 6:
+		return 1
+	}
+	return 2
+}
+
+// closeTerms returns the indexed terms nearest to word, closest and then most
+// common first
+func (c *Corpus) closeTerms(word string) []closeTerm {
+	n := len([]rune(word))
+	edits := maxEdits(n)
+	if edits == 0 {
+		return nil
+	}
+	grams := termGrams(word)
+	shared := make(map[string]int)
+	for _, g := range grams {
+		for _, term := range c.Trigrams[g] {
+			shared[term]++
+		}
+	}
+
+	var terms []closeTerm
+	for term, count := range shared {
+		if count < len(grams)-4*edits || term == word {
+			continue
+		}
+		if d := n - len([]rune(term)); d > edits || d < -edits {
+			continue
+		}
+		if dist := editDistance(word, term); dist <= edits {
+			terms = append(terms, closeTerm{term, dist, len(c.Index.Index[term])})
+		}
+	}
+	sort.Slice(terms, func(i, j int) bool {
+		if terms[i].dist != terms[j].dist {
+			return terms[i].dist < terms[j].dist
+		}
+		if terms[i].df != terms[j].df {
+			return terms[i].df > terms[j].df
+		}
+		return terms[i].term < terms[j].term
+	})
+	if len(terms) > maxExpansions {
+		terms = terms[:maxExpansions]
+	}
+	return terms
+}
+
+// weight is how much a match on a close term counts for
+func (t closeTerm) weight() float64 {
+	return math.Pow(fuzzyWeight, float64(t.dist))
+}
+
+// editDistance is the number of single rune insertions, deletions,
+// substitutions and transpositions of adjacent runes needed to turn a into b,
+// counting each rune at most once. Transpositions are the commonest typo.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	//d[i][j] is the distance between ra[:i] and rb[:j]
+	d := make([][]int, len(ra)+1)
+	for i := range d {
+		d[i] = make([]int, len(rb)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			d[i][j] = d[i-1][j-1] + cost
+			if d[i-1][j]+1 < d[i][j] {
+				d[i][j] = d[i-1][j] + 1
+			}
+			if d[i][j-1]+1 < d[i][j] {
+				d[i][j] = d[i][j-1] + 1
+			}
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] && d[i-2][j-2]+1 < d[i][j] {
+				d[i][j] = d[i-2][j-2] + 1
+			}
+		}
+	}
+	return d[len(ra)][len(rb)]
+}
+
+// known reports whether a query word needs no correction: it is indexed as
+// written or as a comment term, or it is a word comments drop anyway
+func (c *Corpus) known(word string) bool {
+	if _, ok := c.Index.Index[word]; ok {
+		return true
+	}
+	if _, ok := c.Index.Index[c.commentTerm(word)]; ok {
+		return true
+	}
+	return c.Analyzer.Drops(word)
+}
+
+// inCode reports whether term occurs anywhere other than in comments
+func (c *Corpus) inCode(term string) bool {
+	for _, docTerm := range c.Index.Index[term] {
+		if docTerm.Comments < anyField.count(docTerm) {
+			return true
+		}
+	}
+	return false
+}
+
+// suggestion picks the close term to replace a misspelled word with. Terms
+// that occur in code are preferred, as comment terms may be stems like
+// "balanc" that are fine to match but odd to suggest.
+func (c *Corpus) suggestion(word string) (string, bool) {
+	terms := c.closeTerms(word)
+	for _, ct := range terms {
+		if c.inCode(ct.term) {
+			return ct.term, true
+		}
+	}
+	if len(terms) > 0 {
+		return terms[0].term, true
+	}
+	return "", false
+}
+
+// correct replaces each unknown word of query with its suggestion, keeping
+// the rest of the query as typed. It reports whether any word was replaced.
+func (c *Corpus) correct(query string) (string, bool) {
+	toks, err := lex(query)
+	if err != nil {
+		return query, false
+	}
+	var b strings.Builder
+	last, changed := 0, false
+	for _, t := range toks {
+		word := strings.ToLower(t.text)
+		if t.kind != tokWord || isQualified(t.text) || c.known(word) {
+			continue
+		}
+		if term, ok := c.suggestion(word); ok {
+			b.WriteString(query[last:t.pos])
+			b.WriteString(term)
+			last = t.pos + len(t.text)
+			changed = true
+		}
+	}
+	b.WriteString(query[last:])
+	return b.String(), changed
+}
+
+// didYouMean returns a corrected form of query if it ranks much better than
+// the results of the query as typed, or ""
+func didYouMean(query string, results Results, s *scorer, opts Options) string {
+	corrected, ok := s.c.correct(query)
+	if !ok {
+		return ""
+	}
+	q, err := ParseQuery(corrected)
+	if err != nil {
+		return ""
+	}
+	better := rank(q, s, opts)
+	if len(better) == 0 {
+		return ""
+	}
+	if len(results) > 0 && better[0].Rank < suggestRatio*results[0].Rank {
+		return ""
+	}
+	return corrected
+}
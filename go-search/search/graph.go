@@ -0,0 +1,56 @@
+package search
+
+import (
+	"flag"
+	"math"
+	"sort"
+
+	"go-search/index"
+)
+
+// DefaultStaticWeight is used when a request doesn't give a static weight
+var DefaultStaticWeight = 0.5
+
+func init() {
+	flag.Float64Var(&DefaultStaticWeight, "static", DefaultStaticWeight, "default weight of a package's static rank in its score, 0 to ignore it")
+}
+
+// importers inverts the import lists of the indexed packages, mapping each
+// import path to the sorted paths of the packages that import it
+func importers(i *index.Index) map[string][]string {
+	imp := make(map[string][]string)
+	for path, pkg := range i.Packages {
+		for _, dep := range pkg.Imports {
+			imp[dep] = append(imp[dep], path)
+		}
+	}
+	for _, paths := range imp {
+		sort.Strings(paths)
+	}
+	return imp
+}
+
+// boost scales a score by the static rank of the package at path. A weight of
+// 0 leaves the score alone, 1 makes it proportional to the static rank.
+func (c *Corpus) boost(score float64, path string, weight float64) float64 {
+	sr, ok := c.StaticRank[path]
+	if !ok || weight == 0 {
+		return score
+	}
+	return score * math.Pow(sr, weight)
+}
+
+// Importers lists the indexed packages that import path
+func Importers(path string) []*index.Package {
+	c := current()
+	var pkgs []*index.Package
+	for _, p := range c.Importers[path] {
+		pkgs = append(pkgs, c.Index.Packages[p])
+	}
+	return pkgs
+}
+
+// Package returns the indexed package at path, or nil
+func Package(path string) *index.Package {
+	return current().Index.Packages[path]
+}
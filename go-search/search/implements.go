@@ -0,0 +1,43 @@
+package search
+
+import (
+	"go/token"
+	"sort"
+
+	"go-search/index"
+)
+
+// An Implementation is a concrete named type that satisfies an interface
+type Implementation struct {
+	Name    string
+	Path    string
+	Pointer bool //only *T has all of the interface's methods
+}
+
+// NamedType returns the named type with the given ID, e.g. io.Reader, or nil
+// if the index doesn't know it. Only indexes built by type checking know any.
+func NamedType(id string) *index.NamedType {
+	return current().Index.Types[id]
+}
+
+// Implementations lists the exported concrete types of the indexed packages
+// whose method sets satisfy iface, sorted by package path and name. Other
+// packages can't use unexported types, so they aren't listed.
+func Implementations(iface *index.NamedType) []Implementation {
+	impls := []Implementation{}
+	for _, t := range current().Index.Types {
+		if t.Interface || !token.IsExported(t.Name) {
+			continue
+		}
+		if ok, pointer := t.Implements(iface); ok {
+			impls = append(impls, Implementation{t.Name, t.Path, pointer})
+		}
+	}
+	sort.Slice(impls, func(i, j int) bool {
+		if impls[i].Path != impls[j].Path {
+			return impls[i].Path < impls[j].Path
+		}
+		return impls[i].Name < impls[j].Name
+	})
+	return impls
+}
@@ -1,56 +1,217 @@
 package search
 
 import (
-	"encoding/gob"
-	"fmt"
 	"log"
-	"os"
+	"math"
+	"strings"
+	"sync/atomic"
 	"time"
+
+	"go-search/analysis"
+	"go-search/index"
+	"go-search/tokenizer"
 )
 
-var index Index
+// loaded holds the *Corpus queries run against. Reloading the index swaps in
+// a new one; queries that are already running finish against the old one.
+var loaded atomic.Value
+
+func init() {
+	loaded.Store(NewCorpus(index.New()))
+}
+
+// current returns the loaded corpus. A request should call it once and use
+// the same corpus throughout.
+func current() *Corpus {
+	return loaded.Load().(*Corpus)
+}
 
-type DocTerm struct {
-	Term      string
-	Pack      string
-	Path      string
-	Functions int
-	Imports   int
-	Packages  int
-	Types     int
-	//Comments  int
+// A Corpus is a loaded index along with the statistics rankers need that
+// aren't stored in it
+type Corpus struct {
+	Index     *index.Index
+	Docs      int            //number of packages
+	DocLen    map[string]int //total term occurrences per package path
+	TotalLen  int            //total term occurrences over all packages
+	AvgDocLen float64
+	//length of each package's TF-IDF term vector, see similar.go
+	Norms map[string]float64
+	//one document per symbol, keyed by symbol ID
+	Symbols *Corpus
+	//map import paths to the paths of the packages that import them
+	Importers map[string][]string
+	//PageRank of each package over the import graph, averaging 1
+	StaticRank map[string]float64
+	//analyzes query words the way the parser analyzed comments
+	Analyzer *analysis.Analyzer
+	//map trigrams to the terms containing them, see fuzzy.go
+	Trigrams map[string][]string
+	//terms offered as completions, sorted by term
+	Completions []Completion
+	//the corpus of production code alone, without test files and files
+	//excluded by build constraints; c itself if the index holds nothing else
+	Production *Corpus
 }
 
-//map full pkg paths to docterm data
-type DocMap map[string]*DocTerm
+// NewCorpus computes the statistics for an index and builds its symbol corpus
+// and production corpus
+func NewCorpus(i *index.Index) *Corpus {
+	c := newCorpus(i.All())
+	c.Symbols = newCorpus(symbolIndex(i.Symbols))
+	c.Importers = importers(i)
+	//a shard's packages are only part of the import graph, so its ranks
+	//were computed over the whole of it when the shards were written
+	c.StaticRank = i.Ranks
+	if c.StaticRank == nil {
+		c.StaticRank = index.PageRank(i.Packages)
+	}
+	c.Completions = c.completions()
+	c.Production = c
+	if len(i.Other) > 0 {
+		p := newCorpus(i.Production())
+		p.Symbols = newCorpus(symbolIndex(productionSymbols(i.Symbols)))
+		p.Importers, p.StaticRank, p.Completions = c.Importers, c.StaticRank, c.Completions
+		p.Production = p
+		c.Production = p
+	}
+	return c
+}
 
-func (d DocMap) String() string {
-	var pretty string
-	pretty += fmt.Sprintln("")
-	for k, v := range d {
-		pretty += fmt.Sprintln("        ", k, ": ", v)
+// productionSymbols returns the symbols declared in production code
+func productionSymbols(symbols map[string]*index.Symbol) map[string]*index.Symbol {
+	prod := make(map[string]*index.Symbol, len(symbols))
+	for id, sym := range symbols {
+		if sym.Origin == "" {
+			prod[id] = sym
+		}
 	}
-	return pretty
+	return prod
 }
 
-type Index struct {
-	Index      map[string]DocMap
-	UniquePkgs int
+// symbolIndex inverts symbols into an index of their own, so a query
+// can be evaluated against symbols the same way as against packages. Symbols
+// are indexed under the words of their name, their whole name, the words of
+// their receiver's or enclosing type's name, their package name and their
+// qualified names, pkg.Name and Type.Method or Type.Field.
+func symbolIndex(symbols map[string]*index.Symbol) *index.Index {
+	si := index.New()
+	add := func(term string, sym *index.Symbol, count func(*index.DocTerm)) {
+		docMap, ok := si.Index[term]
+		if !ok {
+			docMap = make(index.DocMap)
+			si.Index[term] = docMap
+		}
+		id := sym.ID()
+		docTerm, ok := docMap[id]
+		if !ok {
+			docTerm = &index.DocTerm{Term: term, Pack: sym.Pack, Path: id}
+			docTerm.AddLoc(index.Loc{Kind: sym.Kind, Name: sym.Name, File: sym.File, Line: sym.Line, Snippet: sym.Signature, Origin: sym.Origin})
+			docMap[id] = docTerm
+		}
+		count(docTerm)
+	}
+	countFunc := func(d *index.DocTerm) { d.Functions++ }
+	countMethod := func(d *index.DocTerm) { d.Methods++ }
+	countType := func(d *index.DocTerm) { d.Types++ }
+	countPack := func(d *index.DocTerm) { d.Packages++ }
+	countField := func(d *index.DocTerm) { d.Fields++ }
+	countInterfaceMethod := func(d *index.DocTerm) { d.InterfaceMethods++ }
+	countConst := func(d *index.DocTerm) { d.Consts++ }
+	countVar := func(d *index.DocTerm) { d.Vars++ }
+
+	for _, sym := range symbols {
+		countName := countFunc
+		switch sym.Kind {
+		case index.KindType:
+			countName = countType
+		case index.KindMethod:
+			countName = countMethod
+		case index.KindField:
+			countName = countField
+		case index.KindInterfaceMethod:
+			countName = countInterfaceMethod
+		case index.KindConst:
+			countName = countConst
+		case index.KindVar:
+			countName = countVar
+		}
+		if sym.Recv != "" {
+			add(strings.ToLower(sym.Recv+"."+sym.Name), sym, countName)
+		}
+		for _, w := range tokenizer.Words(sym.Name) {
+			add(w, sym, countName)
+		}
+		for _, w := range tokenizer.Words(sym.Recv) {
+			add(w, sym, countType)
+		}
+		add(strings.ToLower(sym.Pack), sym, countPack)
+		add(strings.ToLower(sym.Pack+"."+sym.Name), sym, countName)
+	}
+	si.UniquePkgs = len(symbols)
+	//kept for queries that match symbols by more than their names
+	si.Symbols = symbols
+	return si
 }
 
-func OpenIndex(indexFile string) {
-	log.Println("Reading index file...")
-	if *specific {
-		log.Println("Srank enabled")
+func newCorpus(i *index.Index) *Corpus {
+	c := &Corpus{Index: i, Docs: i.UniquePkgs, DocLen: make(map[string]int), Analyzer: analysis.New(i.Analysis)}
+	c.Trigrams = trigrams(i)
+	c.Norms = make(map[string]float64)
+	total := 0
+	for _, docMap := range i.Index {
+		for path, docTerm := range docMap {
+			n := termFreq(docTerm)
+			c.DocLen[path] += int(n)
+			total += int(n)
+			w := c.tfidf(docTerm, len(docMap))
+			c.Norms[path] += w * w
+		}
 	}
-	file, err := os.Open(indexFile)
+	for path, sq := range c.Norms {
+		c.Norms[path] = math.Sqrt(sq)
+	}
+	c.TotalLen = total
+	if len(c.DocLen) > 0 {
+		c.AvgDocLen = float64(total) / float64(len(c.DocLen))
+	}
+	return c
+}
+
+// commentTerm is the term a query word is indexed under in doc comments, or
+// "" if it isn't indexed there at all
+func (c *Corpus) commentTerm(word string) string {
+	return c.Analyzer.Term(word)
+}
+
+// OpenIndex loads the index file written by the parser. A file that is
+// missing, corrupt or was written with a different schema is an error rather
+// than an empty index.
+func OpenIndex(indexFile string) error {
+	r, err := ranker("")
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
+	log.Printf("Ranking with %T by default\n", r)
+	_, err = load(indexFile)
+	return err
+}
+
+// load reads an index file and swaps it in for the loaded corpus once its
+// statistics are computed. Loads are serialized; a failed load leaves the
+// loaded corpus alone.
+func load(indexFile string) (index.Header, error) {
+	loading.Lock()
+	defer loading.Unlock()
+	log.Println("Reading index file...")
 	t0 := time.Now()
-	dec := gob.NewDecoder(file)
-	dec.Decode(&index)
+	i, hdr, err := index.Open(indexFile)
+	if err != nil {
+		return hdr, err
+	}
 	t1 := time.Now()
-	log.Printf("Read in index of size %v\n", len(index.Index))
-	log.Printf("Decoding took %v\n", t1.Sub(t0))
+	loaded.Store(NewCorpus(i))
+	loadedFile = indexFile
+	log.Printf("Read in index of size %v, built %v\n", hdr.Stats.Terms, hdr.Created.Format(time.RFC1123))
+	log.Printf("Decoding took %v, loading %v\n", t1.Sub(t0), time.Since(t0))
+	return hdr, nil
 }
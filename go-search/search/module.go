@@ -0,0 +1,47 @@
+package search
+
+import "sort"
+
+// A ModuleCount is the number of results from one module
+type ModuleCount struct {
+	Module string
+	Count  int
+}
+
+// modules counts the results from each module. Results from packages outside
+// of any module aren't counted.
+func modules(results Results) []ModuleCount {
+	counts := make(map[string]int)
+	for _, r := range results {
+		if r.Module != "" {
+			counts[r.Module]++
+		}
+	}
+	return sortModules(counts)
+}
+
+// SumModules adds up the module counts of several sets of results
+func SumModules(sets ...[]ModuleCount) []ModuleCount {
+	counts := make(map[string]int)
+	for _, set := range sets {
+		for _, mc := range set {
+			counts[mc.Module] += mc.Count
+		}
+	}
+	return sortModules(counts)
+}
+
+// sortModules lists counts, most first
+func sortModules(counts map[string]int) []ModuleCount {
+	mcs := make([]ModuleCount, 0, len(counts))
+	for m, n := range counts {
+		mcs = append(mcs, ModuleCount{m, n})
+	}
+	sort.Slice(mcs, func(i, j int) bool {
+		if mcs[i].Count != mcs[j].Count {
+			return mcs[i].Count > mcs[j].Count
+		}
+		return mcs[i].Module < mcs[j].Module
+	})
+	return mcs
+}
@@ -0,0 +1,104 @@
+package search
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"time"
+)
+
+const (
+	DefaultLimit = 150
+	MaxLimit     = 1000
+	//no page starts further in, so offset+limit can't overflow
+	MaxOffset = 100000
+)
+
+var errBadCursor = errors.New("invalid cursor")
+
+// A Page is one slice of the ranked results for a query
+type Page struct {
+	Results    Results
+	Total      int           //number of packages matching the query
+	Took       time.Duration //time spent evaluating the query
+	NextCursor string        //empty on the last page
+	//a corrected query that ranks much better, only on the first page
+	DidYouMean string
+	//number of results from each module, most first
+	Modules []ModuleCount
+}
+
+// Bounds returns the offset and limit of the page of results for query that
+// opts select
+func Bounds(query string, opts Options) (offset, limit int, err error) {
+	limit = opts.Limit
+	if limit <= 0 {
+		limit = DefaultLimit
+	}
+	if limit > MaxLimit {
+		limit = MaxLimit
+	}
+
+	offset = opts.Offset
+	if opts.Cursor != "" {
+		if offset, err = decodeCursor(opts.Cursor, fingerprint(query, opts)); err != nil {
+			return 0, 0, err
+		}
+	}
+	if offset < 0 {
+		return 0, 0, fmt.Errorf("negative offset %v", offset)
+	}
+	if offset > MaxOffset {
+		return 0, 0, fmt.Errorf("offset %v past the maximum of %v", offset, MaxOffset)
+	}
+	return offset, limit, nil
+}
+
+// Paginate cuts the page selected by opts out of the ranked results for
+// query. results may be just the best of them, as long as it holds at least
+// one more than the end of the page if there are more; total counts them all.
+func Paginate(results Results, total int, query string, opts Options) (Page, error) {
+	offset, limit, err := Bounds(query, opts)
+	if err != nil {
+		return Page{}, err
+	}
+
+	page := Page{Total: total}
+	if offset >= len(results) {
+		page.Results = Results{}
+		return page, nil
+	}
+	end := offset + limit
+	if end < len(results) {
+		page.NextCursor = encodeCursor(end, fingerprint(query, opts))
+	} else {
+		end = len(results)
+	}
+	page.Results = results[offset:end]
+	return page, nil
+}
+
+// fingerprint identifies the query and ranking a cursor was issued for, so
+// a cursor can't be replayed against a different result list
+func fingerprint(query string, opts Options) uint32 {
+	return crc32.ChecksumIEEE([]byte(fmt.Sprintf("%q %q %v %q %v %q %v", query, opts.Ranker, opts.Weights, opts.Module, opts.Production,
+		opts.Kind, opts.StaticWeight)))
+}
+
+func encodeCursor(offset int, fp uint32) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d.%08x", offset, fp)))
+}
+
+func decodeCursor(cursor string, fp uint32) (int, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, errBadCursor
+	}
+	var offset int
+	var got uint32
+	if _, err := fmt.Sscanf(string(raw), "%d.%08x", &offset, &got); err != nil || got != fp {
+		return 0, errBadCursor
+	}
+	return offset, nil
+}
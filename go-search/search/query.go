@@ -0,0 +1,791 @@
+package search
+
+import (
+	"fmt"
+	"go/token"
+	"strings"
+	"unicode"
+
+	"go-search/index"
+	"go-search/shape"
+	"go-search/tokenizer"
+)
+
+// The query language, loosest binding first:
+//
+//	query   = { clause }                  bag of clauses, any may match
+//	clause  = [ "+" | "-" ] or            + must match, - must not match
+//	or      = and { "OR" and }
+//	and     = primary { "AND" primary }
+//	primary = "(" query ")" | [ field ":" ] ( word | qualified | phrase | sig )
+//	qualified = ident "." ident           Type.Method or pkg.Name
+//	phrase  = '"' words '"'               words split as go-search/tokenizer does
+//	sig     = "func(" types ")" [ type | "(" types ")" ]
+//	field   = "func" | "method" | "type" | "import" | "pkg" | "doc" |
+//	          "field" | "imethod" | "const" | "var"
+//
+// A bare list of words behaves as it always has: every word is optional and a
+// package scores the sum of the words it contains. AND and OR must be upper
+// case, lower case "and" and "or" are searched for as words. A qualified name
+// only matches that exact method, field or package member, and ranks above
+// the same words searched for separately. A word that isn't indexed at all
+// matches the indexed words a typo or two away from it instead, see
+// fuzzy.go. A signature matches functions and methods by the types of their
+// parameters and results, written as go-search/shape normalises them. A
+// single result type ends at the next space, so write func() (chan int) for
+// results with spaces in them. Signatures can only be qualified by func: or
+// method:. For example
+//
+//	import:net/http AND type:router
+//	+"read all" -ioutil
+//	(json OR xml) +func:marshal
+//	Server.ServeHTTP
+//	func(io.Reader) ([]uint8, error)
+
+// A field restricts a term to one of the counters in an index.DocTerm
+type field int
+
+const (
+	anyField field = iota
+	funcField
+	methodField
+	typeField
+	importField
+	pkgField
+	structField          //struct fields
+	interfaceMethodField //methods declared by interfaces
+	constField
+	varField
+	docField //last, the fields before it are the kinds of declaration
+)
+
+var fieldNames = map[string]field{
+	"func":    funcField,
+	"method":  methodField,
+	"type":    typeField,
+	"import":  importField,
+	"pkg":     pkgField,
+	"doc":     docField,
+	"field":   structField,
+	"imethod": interfaceMethodField,
+	"const":   constField,
+	"var":     varField,
+}
+
+// count returns how often the term was seen in this field
+func (f field) count(d *index.DocTerm) int {
+	switch f {
+	case funcField:
+		return d.Functions
+	case methodField:
+		return d.Methods
+	case typeField:
+		return d.Types
+	case importField:
+		return d.Imports
+	case pkgField:
+		return d.Packages
+	case docField:
+		return d.Comments
+	case structField:
+		return d.Fields
+	case interfaceMethodField:
+		return d.InterfaceMethods
+	case constField:
+		return d.Consts
+	case varField:
+		return d.Vars
+	}
+	return d.Functions + d.Methods + d.Types + d.Imports + d.Packages + d.Comments +
+		d.Fields + d.InterfaceMethods + d.Consts + d.Vars
+}
+
+// A Query is a parsed search query that can be evaluated against an index
+type Query interface {
+	eval(s *scorer) ResultMap
+	String() string
+}
+
+type occur int
+
+const (
+	should occur = iota
+	must
+	mustNot
+)
+
+type clause struct {
+	occur occur
+	q     Query
+}
+
+// bagQuery matches packages that match every must clause and no mustNot
+// clause. Without must clauses at least one should clause has to match.
+type bagQuery struct {
+	clauses []clause
+}
+
+type orQuery struct {
+	qs []Query
+}
+
+type andQuery struct {
+	qs []Query
+}
+
+type termQuery struct {
+	field     field
+	text      string
+	qualified bool
+}
+
+// qualifiedBoost multiplies the score of Type.Method and pkg.Name terms
+const qualifiedBoost = 2.0
+
+// sigQuery matches functions and methods by their signature. A package
+// scores as its best matching function.
+type sigQuery struct {
+	field field //anyField, funcField or methodField
+	sig   shape.Signature
+}
+
+// signatureWeight is the score of a function whose signature matches a query
+// exactly, about that of a rare word matching its name
+const signatureWeight = 4.0
+
+// phraseQuery matches packages where all of its words occur together in the
+// same kind of declaration. The index doesn't keep word positions, so this is
+// as close to an exact phrase as it can get.
+type phraseQuery struct {
+	field field
+	words []string
+}
+
+func (r ResultMap) get(d *index.DocTerm) *Result {
+	result, ok := r[d.Path]
+	if !ok {
+		result = NewResult()
+		result.Pack = d.Pack
+		result.Path = d.Path
+		r[d.Path] = result
+	}
+	return result
+}
+
+// add folds the rank, context and matched terms of o into r
+func (r *Result) add(o *Result) {
+	r.Rank += o.Rank
+	r.Context = append(r.Context, o.Context...)
+	if r.Name == "" {
+		r.Name = o.Name
+	} else if o.Name != "" {
+		r.Name += ", " + o.Name
+	}
+}
+
+func (r ResultMap) merge(o ResultMap) {
+	for path, result := range o {
+		if mine, ok := r[path]; ok {
+			mine.add(result)
+		} else {
+			r[path] = result
+		}
+	}
+}
+
+func (r ResultMap) intersect(o ResultMap) ResultMap {
+	both := make(ResultMap)
+	for path, result := range r {
+		if other, ok := o[path]; ok {
+			result.add(other)
+			both[path] = result
+		}
+	}
+	return both
+}
+
+func (q *bagQuery) eval(s *scorer) ResultMap {
+	var musts ResultMap
+	shoulds := make(ResultMap)
+	var excluded []ResultMap
+
+	for _, c := range q.clauses {
+		r := c.q.eval(s)
+		switch c.occur {
+		case must:
+			if musts == nil {
+				musts = r
+			} else {
+				musts = musts.intersect(r)
+			}
+		case mustNot:
+			excluded = append(excluded, r)
+		default:
+			shoulds.merge(r)
+		}
+	}
+
+	results := shoulds
+	if musts != nil {
+		//shoulds only add to the score of packages that already match
+		for path, result := range musts {
+			if extra, ok := shoulds[path]; ok {
+				result.add(extra)
+			}
+		}
+		results = musts
+	}
+	for _, r := range excluded {
+		for path := range r {
+			delete(results, path)
+		}
+	}
+	return results
+}
+
+func (q *orQuery) eval(s *scorer) ResultMap {
+	results := make(ResultMap)
+	for _, sub := range q.qs {
+		results.merge(sub.eval(s))
+	}
+	return results
+}
+
+func (q *andQuery) eval(s *scorer) ResultMap {
+	results := q.qs[0].eval(s)
+	for _, sub := range q.qs[1:] {
+		if len(results) == 0 {
+			break
+		}
+		results = results.intersect(sub.eval(s))
+	}
+	return results
+}
+
+func (q *termQuery) eval(s *scorer) ResultMap {
+	results := make(ResultMap)
+	//a misspelled word matches the terms closest to it at reduced weight
+	if !q.qualified && !s.known(q.text) {
+		for _, ct := range s.c.closeTerms(q.text) {
+			close := &termQuery{field: q.field, text: ct.term}
+			close.addTo(results, s, ct.weight())
+		}
+		return results
+	}
+	q.addTo(results, s, 1)
+	return results
+}
+
+// addTo scores the postings of the term into results, scaled by weight
+func (q *termQuery) addTo(results ResultMap, s *scorer, weight float64) {
+	add := func(term string, f field) {
+		docMap, df := s.postings(term)
+		for _, docTerm := range docMap {
+			if f.count(docTerm) == 0 {
+				continue
+			}
+			result := results.get(docTerm)
+			score := weight * s.score(docTerm, df, f)
+			if q.qualified {
+				score *= qualifiedBoost
+			}
+			result.Rank += score
+			result.Context = append(result.Context, *docTerm)
+			result.Name = q.String()
+		}
+	}
+
+	//identifiers are indexed as written, comments as analyzed
+	comment := s.c.commentTerm(q.text)
+	switch {
+	case q.field == docField:
+		add(comment, docField)
+	case q.field == anyField && comment != q.text:
+		add(q.text, anyField)
+		add(comment, docField)
+	default:
+		add(q.text, q.field)
+	}
+}
+
+func (q *sigQuery) eval(s *scorer) ResultMap {
+	results := make(ResultMap)
+	if s.syms != nil {
+		for _, r := range q.eval(s.syms) {
+			sym := s.syms.c.Index.Symbols[r.Path]
+			if best, ok := results[sym.Path]; ok && best.Rank >= r.Rank {
+				continue
+			}
+			r.Pack, r.Path = sym.Pack, sym.Path
+			results[sym.Path] = r
+		}
+		return results
+	}
+
+	for id, sym := range s.c.Index.Symbols {
+		f, w := funcField, s.weights.Functions
+		switch sym.Kind {
+		case index.KindFunc:
+		case index.KindMethod:
+			f, w = methodField, s.weights.Methods
+		default:
+			continue
+		}
+		if q.field != anyField && q.field != f {
+			continue
+		}
+		match := shape.Match(q.sig, shape.Signature{Params: sym.Params, Results: sym.Results, TypeParams: sym.TypeParams})
+		if match == 0 {
+			continue
+		}
+		loc := index.Loc{Kind: sym.Kind, Name: sym.Name, File: sym.File, Line: sym.Line, Snippet: sym.Signature, Origin: sym.Origin}
+		context := index.DocTerm{Term: q.sig.String(), Pack: sym.Pack, Path: id}
+		context.AddLoc(loc)
+		if f == methodField {
+			context.Methods = 1
+		} else {
+			context.Functions = 1
+		}
+		result := NewResult()
+		result.Pack, result.Path = sym.Pack, id
+		result.Rank = signatureWeight * match * w
+		result.Context = []index.DocTerm{context}
+		result.Name = q.String()
+		results[id] = result
+	}
+	return results
+}
+
+func (q *phraseQuery) eval(s *scorer) ResultMap {
+	results := make(ResultMap)
+	fields := []field{q.field}
+	if q.field == anyField {
+		fields = []field{funcField, methodField, typeField, importField, pkgField,
+			structField, interfaceMethodField, constField, varField, docField}
+	}
+	for _, f := range fields {
+		docMaps, dfs := s.phrasePostings(q.words, f)
+		if docMaps == nil {
+			continue
+		}
+		for path := range docMaps[0] {
+			if _, done := results[path]; done || !phraseIn(docMaps, path, f) {
+				continue
+			}
+			result := results.get(docMaps[0][path])
+			for i, docMap := range docMaps {
+				result.Rank += s.score(docMap[path], dfs[i], f)
+				result.Context = append(result.Context, *docMap[path])
+			}
+			result.Name = q.String()
+		}
+	}
+	return results
+}
+
+// phrasePostings looks up the postings and document frequency of each word
+// of a phrase as it would be indexed in field f. Stop words are skipped in
+// comments. It returns nil if any word isn't indexed at all.
+func (s *scorer) phrasePostings(words []string, f field) ([]index.DocMap, []int) {
+	var docMaps []index.DocMap
+	var dfs []int
+	for _, w := range words {
+		if f == docField {
+			if w = s.c.commentTerm(w); w == "" {
+				continue
+			}
+		}
+		docMap, df := s.postings(w)
+		if docMap == nil {
+			return nil, nil
+		}
+		docMaps = append(docMaps, docMap)
+		dfs = append(dfs, df)
+	}
+	return docMaps, dfs
+}
+
+// phraseIn reports whether every word of a phrase occurs in field f of path
+func phraseIn(docMaps []index.DocMap, path string, f field) bool {
+	for _, docMap := range docMaps {
+		docTerm, ok := docMap[path]
+		if !ok || f.count(docTerm) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (f field) prefix() string {
+	for name, ff := range fieldNames {
+		if ff == f {
+			return name + ":"
+		}
+	}
+	return ""
+}
+
+func (q *bagQuery) String() string {
+	parts := make([]string, len(q.clauses))
+	for i, c := range q.clauses {
+		switch c.occur {
+		case must:
+			parts[i] = "+" + c.q.String()
+		case mustNot:
+			parts[i] = "-" + c.q.String()
+		default:
+			parts[i] = c.q.String()
+		}
+	}
+	return "(" + strings.Join(parts, " ") + ")"
+}
+
+func (q *orQuery) String() string  { return joinQueries(q.qs, " OR ") }
+func (q *andQuery) String() string { return joinQueries(q.qs, " AND ") }
+func (q *termQuery) String() string {
+	return q.field.prefix() + q.text
+}
+func (q *sigQuery) String() string {
+	return q.field.prefix() + q.sig.String()
+}
+func (q *phraseQuery) String() string {
+	return q.field.prefix() + `"` + strings.Join(q.words, " ") + `"`
+}
+
+func joinQueries(qs []Query, sep string) string {
+	parts := make([]string, len(qs))
+	for i, q := range qs {
+		parts[i] = q.String()
+	}
+	return "(" + strings.Join(parts, sep) + ")"
+}
+
+// Parsing
+
+type itemKind int
+
+const (
+	tokWord itemKind = iota
+	tokPhrase
+	tokSignature
+	tokLParen
+	tokRParen
+	tokPlus
+	tokMinus
+	tokAnd
+	tokOr
+	tokEOF
+)
+
+type item struct {
+	kind  itemKind
+	field field
+	text  string
+	pos   int //byte offset of text in the query
+}
+
+// lex splits a query string into tokens. Field qualifiers are folded into the
+// word or phrase they qualify.
+func lex(query string) ([]item, error) {
+	var toks []item
+	s := query
+	for {
+		s = strings.TrimLeftFunc(s, unicode.IsSpace)
+		if s == "" {
+			break
+		}
+		switch s[0] {
+		case '(':
+			toks = append(toks, item{kind: tokLParen})
+			s = s[1:]
+			continue
+		case ')':
+			toks = append(toks, item{kind: tokRParen})
+			s = s[1:]
+			continue
+		case '+':
+			toks = append(toks, item{kind: tokPlus})
+			s = s[1:]
+			continue
+		case '-':
+			toks = append(toks, item{kind: tokMinus})
+			s = s[1:]
+			continue
+		}
+
+		tok := item{kind: tokWord}
+		if i := strings.IndexByte(s, ':'); i > 0 && !strings.ContainsAny(s[:i], " \t\n()\"") {
+			f, ok := fieldNames[strings.ToLower(s[:i])]
+			if !ok {
+				return nil, fmt.Errorf("unknown field %q, want one of func, method, type, import, pkg, doc, field, imethod, const or var", s[:i])
+			}
+			tok.field = f
+			s = s[i+1:]
+		}
+
+		tok.pos = len(query) - len(s)
+		if strings.HasPrefix(s, "func(") {
+			end, err := signatureEnd(s)
+			if err != nil {
+				return nil, err
+			}
+			tok.kind = tokSignature
+			tok.text = s[:end]
+			s = s[end:]
+		} else if strings.HasPrefix(s, `"`) {
+			tok.pos++
+			end := strings.IndexByte(s[1:], '"')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated phrase %s", s)
+			}
+			tok.kind = tokPhrase
+			tok.text = s[1 : end+1]
+			s = s[end+2:]
+		} else {
+			end := strings.IndexFunc(s, func(r rune) bool {
+				return unicode.IsSpace(r) || r == '(' || r == ')' || r == '"'
+			})
+			if end < 0 {
+				end = len(s)
+			}
+			tok.text = s[:end]
+			s = s[end:]
+			if tok.field == anyField {
+				switch tok.text {
+				case "AND":
+					tok.kind = tokAnd
+				case "OR":
+					tok.kind = tokOr
+				}
+			}
+		}
+		if (tok.kind == tokWord || tok.kind == tokPhrase) && tok.text == "" {
+			return nil, fmt.Errorf("empty %vterm", tok.field.prefix())
+		}
+		toks = append(toks, tok)
+	}
+	return append(toks, item{kind: tokEOF}), nil
+}
+
+// signatureEnd returns the length of the signature s starts with: func, its
+// parameters in parentheses, then either results in parentheses or a single
+// result type running to the next space or unmatched parenthesis
+func signatureEnd(s string) (int, error) {
+	end := closing(s, len("func"))
+	if end < 0 {
+		return 0, fmt.Errorf("unterminated signature %s", s)
+	}
+	rest := strings.TrimLeft(s[end:], " \t")
+	start := len(s) - len(rest)
+	if strings.HasPrefix(rest, "(") {
+		if n := closing(s, start); n > 0 {
+			return n, nil
+		}
+		return 0, fmt.Errorf("unterminated signature %s", s)
+	}
+	if rest == "" || strings.ContainsRune("+-)\"", rune(rest[0])) {
+		return end, nil
+	}
+	depth := 0
+	for i, r := range rest {
+		switch {
+		case r == '(' || r == '[':
+			depth++
+		case (r == ')' || r == ']') && depth > 0:
+			depth--
+		case depth == 0 && (r == ')' || unicode.IsSpace(r)):
+			return resultEnd(s, end, start, rest[:i]), nil
+		}
+	}
+	return resultEnd(s, end, start, rest), nil
+}
+
+// resultEnd is where a signature with the result type t, if any, ends. The
+// operators AND and OR aren't types.
+func resultEnd(s string, end, start int, t string) int {
+	if t == "AND" || t == "OR" {
+		return end
+	}
+	return start + len(t)
+}
+
+// closing returns the offset just past the parenthesis that closes the one at
+// s[open], or -1 if it isn't closed
+func closing(s string, open int) int {
+	depth := 0
+	for i := open; i < len(s); i++ {
+		switch s[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return -1
+}
+
+type queryParser struct {
+	toks []item
+	pos  int
+}
+
+// ParseQuery parses a query string into a Query
+func ParseQuery(query string) (Query, error) {
+	toks, err := lex(query)
+	if err != nil {
+		return nil, err
+	}
+	p := &queryParser{toks: toks}
+	q, err := p.parseBag()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, fmt.Errorf("unexpected %v", t)
+	}
+	return q, nil
+}
+
+func (p *queryParser) peek() item { return p.toks[p.pos] }
+func (p *queryParser) next() item {
+	t := p.toks[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *queryParser) parseBag() (*bagQuery, error) {
+	bag := &bagQuery{}
+	for {
+		c := clause{occur: should}
+		switch p.peek().kind {
+		case tokEOF, tokRParen:
+			return bag, nil
+		case tokPlus:
+			p.next()
+			c.occur = must
+		case tokMinus:
+			p.next()
+			c.occur = mustNot
+		}
+		q, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		c.q = q
+		bag.clauses = append(bag.clauses, c)
+	}
+}
+
+func (p *queryParser) parseOr() (Query, error) {
+	q, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	or := &orQuery{qs: []Query{q}}
+	for p.peek().kind == tokOr {
+		p.next()
+		q, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		or.qs = append(or.qs, q)
+	}
+	if len(or.qs) == 1 {
+		return q, nil
+	}
+	return or, nil
+}
+
+func (p *queryParser) parseAnd() (Query, error) {
+	q, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	and := &andQuery{qs: []Query{q}}
+	for p.peek().kind == tokAnd {
+		p.next()
+		q, err := p.parsePrimary()
+		if err != nil {
+			return nil, err
+		}
+		and.qs = append(and.qs, q)
+	}
+	if len(and.qs) == 1 {
+		return q, nil
+	}
+	return and, nil
+}
+
+func (p *queryParser) parsePrimary() (Query, error) {
+	t := p.next()
+	switch t.kind {
+	case tokLParen:
+		q, err := p.parseBag()
+		if err != nil {
+			return nil, err
+		}
+		if p.next().kind != tokRParen {
+			return nil, fmt.Errorf("missing )")
+		}
+		if len(q.clauses) == 0 {
+			return nil, fmt.Errorf("empty ()")
+		}
+		return q, nil
+	case tokWord:
+		return &termQuery{field: t.field, text: strings.ToLower(t.text), qualified: isQualified(t.text)}, nil
+	case tokPhrase:
+		var words []string
+		for _, w := range strings.Fields(t.text) {
+			for _, part := range tokenizer.Split(w) {
+				words = append(words, strings.ToLower(part))
+			}
+		}
+		if len(words) == 1 {
+			return &termQuery{field: t.field, text: words[0]}, nil
+		}
+		return &phraseQuery{field: t.field, words: words}, nil
+	case tokSignature:
+		if t.field != anyField && t.field != funcField && t.field != methodField {
+			return nil, fmt.Errorf("%vfunc(...): signatures can only be qualified by func: or method:", t.field.prefix())
+		}
+		sig, err := shape.Parse(t.text)
+		if err != nil {
+			return nil, err
+		}
+		return &sigQuery{field: t.field, sig: sig}, nil
+	}
+	return nil, fmt.Errorf("unexpected %v", t)
+}
+
+func (t item) String() string {
+	switch t.kind {
+	case tokLParen:
+		return "("
+	case tokRParen:
+		return ")"
+	case tokPlus:
+		return "+"
+	case tokMinus:
+		return "-"
+	case tokAnd:
+		return "AND"
+	case tokOr:
+		return "OR"
+	case tokEOF:
+		return "end of query"
+	case tokPhrase:
+		return t.field.prefix() + `"` + t.text + `"`
+	}
+	return t.field.prefix() + t.text
+}
+
+// isQualified reports whether a word is a qualified name like Reader.Read
+func isQualified(word string) bool {
+	parts := strings.Split(word, ".")
+	return len(parts) == 2 && token.IsIdentifier(parts[0]) && token.IsIdentifier(parts[1])
+}
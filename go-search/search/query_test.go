@@ -0,0 +1,152 @@
+package search
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"go-search/index"
+)
+
+func TestParseQuery(t *testing.T) {
+	tests := []struct {
+		query, want string
+	}{
+		{"read write", "(read write)"},
+		{"+read -write", "(+read -write)"},
+		{"and or", "(and or)"},
+		{"json OR xml AND marshal", "((json OR (xml AND marshal)))"},
+		{"import:net/http AND type:router", "((import:net/http AND type:router))"},
+		{"(json OR xml) +func:marshal", "(((json OR xml)) +func:marshal)"},
+		{`"read all" -ioutil`, `("read all" -ioutil)`},
+		{`doc:"ReadAll"`, `(doc:"read all")`},
+		{`"Read"`, "(read)"},
+		{"Server.ServeHTTP", "(server.servehttp)"},
+		{"func(io.Reader) ([]byte, error)", "(func(io.Reader) ([]uint8, error))"},
+		{"method:func() (chan int) close", "(method:func() chan int close)"},
+		{"func(int) string AND read", "((func(int) string AND read))"},
+		{"func(int) AND read", "((func(int) AND read))"},
+		{"", "()"},
+	}
+	for _, tt := range tests {
+		q, err := ParseQuery(tt.query)
+		if err != nil {
+			t.Errorf("ParseQuery(%q): %v", tt.query, err)
+			continue
+		}
+		if got := q.String(); got != tt.want {
+			t.Errorf("ParseQuery(%q) = %s, want %s", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestParseQueryErrors(t *testing.T) {
+	tests := []struct {
+		query, want string
+	}{
+		{"foo:bar", `unknown field "foo"`},
+		{`"read all`, "unterminated phrase"},
+		{"func(int", "unterminated signature"},
+		{"func(int) (string", "unterminated signature"},
+		{"()", "empty ()"},
+		{"(read", "missing )"},
+		{"doc:", "empty doc:term"},
+		{`""`, "empty term"},
+		{"type:func(int)", "signatures can only be qualified by func: or method:"},
+		{"read)", "unexpected )"},
+		{"AND read", "unexpected AND"},
+		{"read OR", "unexpected end of query"},
+		{"+", "unexpected end of query"},
+	}
+	for _, tt := range tests {
+		_, err := ParseQuery(tt.query)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("ParseQuery(%q) error = %v, want %q", tt.query, err, tt.want)
+		}
+	}
+}
+
+func TestQualified(t *testing.T) {
+	q, err := ParseQuery("Server.ServeHTTP http.Get a.b.c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []bool{true, true, false}
+	for i, c := range q.(*bagQuery).clauses {
+		if got := c.q.(*termQuery).qualified; got != want[i] {
+			t.Errorf("%v qualified = %v, want %v", c.q, got, want[i])
+		}
+	}
+}
+
+// testCorpus indexes three packages: a with read and json, b with read and
+// write, and c with write and the type xml
+func testCorpus() *Corpus {
+	i := index.New()
+	add := func(term, path string, funcs, types int) {
+		if i.Index[term] == nil {
+			i.Index[term] = make(index.DocMap)
+		}
+		i.Index[term][path] = &index.DocTerm{Term: term, Pack: path, Path: path, Functions: funcs, Types: types}
+		i.Package(path, path)
+	}
+	add("read", "a", 2, 0)
+	add("json", "a", 1, 0)
+	add("read", "b", 1, 0)
+	add("write", "b", 1, 0)
+	add("write", "c", 1, 0)
+	add("xml", "c", 0, 1)
+	i.UniquePkgs = len(i.Packages)
+	return NewCorpus(i)
+}
+
+func TestEval(t *testing.T) {
+	r, err := ranker("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newScorer(testCorpus(), r, DefaultWeights, nil, nil)
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"read", []string{"a", "b"}},
+		{"read write", []string{"a", "b", "c"}},
+		//a must clause limits the results, should clauses only add to them
+		{"+read write", []string{"a", "b"}},
+		{"+read +write", []string{"b"}},
+		{"read -write", []string{"a"}},
+		{"+read -json", []string{"b"}},
+		{"-read", []string{}},
+		{"json OR xml", []string{"a", "c"}},
+		{"read AND write", []string{"b"}},
+		{"+(json OR xml) -write", []string{"a"}},
+		{"type:xml", []string{"c"}},
+		{"func:xml", []string{}},
+	}
+	for _, tt := range tests {
+		q, err := ParseQuery(tt.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := []string{}
+		for path := range q.eval(s) {
+			got = append(got, path)
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q matched %q, want %q", tt.query, got, tt.want)
+		}
+	}
+
+	//should clauses add to the score of packages that match the must clauses
+	scores := make(map[string]float64)
+	for _, query := range []string{"+read", "+read write"} {
+		q, _ := ParseQuery(query)
+		scores[query] = q.eval(s)["b"].Rank
+	}
+	if scores["+read write"] <= scores["+read"] {
+		t.Errorf("b scores %v for +read write, want more than the %v of +read", scores["+read write"], scores["+read"])
+	}
+}
@@ -1,86 +1,390 @@
 package search
 
 import (
+	"fmt"
 	"log"
 	"math"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 	"flag"
+
+	"go-search/index"
+)
+
+var (
+	specific    = flag.Bool("srank", false, "use specificity heuristic in ranking, same as -rank srank")
+	defaultRank = flag.String("rank", "tfidf", "ranker used when a request doesn't name one: tfidf, bm25 or srank")
 )
 
-var specific = flag.Bool("srank", false, "use specificity heuristic in ranking")
+// A Ranker scores how well one term matches one package. df is the number of
+// packages the term occurs in, w how much an occurrence in each kind of
+// declaration counts for. docTerm is a copy; the index itself is shared by
+// every request and is never written to while searching.
+type Ranker interface {
+	Score(docTerm index.DocTerm, df int, c *Corpus, w Weights) float64
+}
+
+// Rankers maps the names requests may choose a ranker by to the ranker
+var Rankers = map[string]Ranker{
+	"tfidf": TFIDF{},
+	"bm25":  BM25{K1: 1.2, B: 0.75},
+	"srank": Specificity{},
+}
+
+// TFIDF scores the weighted occurrence count of a term by its inverse
+// document frequency
+type TFIDF struct{}
+
+func (TFIDF) Score(docTerm index.DocTerm, df int, c *Corpus, w Weights) float64 {
+	return w.Freq(docTerm) * math.Log(float64(c.Docs)/float64(df))
+}
+
+// BM25 is Okapi BM25. K1 controls how quickly repeated occurrences of a term
+// saturate, B how strongly scores are normalised by package size.
+type BM25 struct {
+	K1, B float64
+}
+
+func (r BM25) Score(docTerm index.DocTerm, df int, c *Corpus, w Weights) float64 {
+	tf := w.Freq(docTerm)
+	n, dfn := float64(c.Docs), float64(df)
+	idf := math.Log(1 + (n-dfn+0.5)/(dfn+0.5))
+	norm := 1.0
+	if c.AvgDocLen > 0 {
+		norm = 1 - r.B + r.B*float64(c.DocLen[docTerm.Path])/c.AvgDocLen
+	}
+	return idf * tf * (r.K1 + 1) / (tf + r.K1*norm)
+}
+
+// Specificity is TF-IDF with declarations weighted over imports: a term that
+// names a function is worth more than one that names a type, and both more
+// than an import. The request's weights are applied on top.
+type Specificity struct{}
+
+var specificityWeights = Weights{Functions: 4, Methods: 4, Types: 2, Imports: 0.5, Packages: 1, Comments: 1,
+	Fields: 2, InterfaceMethods: 4, Consts: 2, Vars: 2}
+
+func (Specificity) Score(docTerm index.DocTerm, df int, c *Corpus, w Weights) float64 {
+	return TFIDF{}.Score(docTerm, df, c, w.Scale(specificityWeights))
+}
+
+// Weights scale the occurrences of a term in each kind of declaration
+type Weights struct {
+	Functions        float64
+	Methods          float64
+	Types            float64
+	Imports          float64
+	Packages         float64
+	Comments         float64
+	Fields           float64
+	InterfaceMethods float64
+	Consts           float64
+	Vars             float64
+}
+
+// DefaultWeights are used for any weight a request leaves out
+var DefaultWeights = Weights{Functions: 1, Methods: 1, Types: 1, Imports: 1, Packages: 1, Comments: 1,
+	Fields: 1, InterfaceMethods: 1, Consts: 1, Vars: 1}
+
+func init() {
+	flag.Var(&DefaultWeights, "weights", "default field weights, e.g. functions=4,imports=0.5")
+}
+
+// Freq is the weighted number of occurrences of a term
+func (w Weights) Freq(docTerm index.DocTerm) float64 {
+	freq := float64(docTerm.Functions) * w.Functions
+	freq += float64(docTerm.Methods) * w.Methods
+	freq += float64(docTerm.Types) * w.Types
+	freq += float64(docTerm.Imports) * w.Imports
+	freq += float64(docTerm.Packages) * w.Packages
+	freq += float64(docTerm.Comments) * w.Comments
+	freq += float64(docTerm.Fields) * w.Fields
+	freq += float64(docTerm.InterfaceMethods) * w.InterfaceMethods
+	freq += float64(docTerm.Consts) * w.Consts
+	freq += float64(docTerm.Vars) * w.Vars
+	return freq
+}
+
+// Scale multiplies each weight by the matching weight in o
+func (w Weights) Scale(o Weights) Weights {
+	return Weights{
+		Functions:        w.Functions * o.Functions,
+		Methods:          w.Methods * o.Methods,
+		Types:            w.Types * o.Types,
+		Imports:          w.Imports * o.Imports,
+		Packages:         w.Packages * o.Packages,
+		Comments:         w.Comments * o.Comments,
+		Fields:           w.Fields * o.Fields,
+		InterfaceMethods: w.InterfaceMethods * o.InterfaceMethods,
+		Consts:           w.Consts * o.Consts,
+		Vars:             w.Vars * o.Vars,
+	}
+}
+
+// only zeroes every weight outside of f
+func (w Weights) only(f field) Weights {
+	switch f {
+	case funcField:
+		return Weights{Functions: w.Functions}
+	case methodField:
+		return Weights{Methods: w.Methods}
+	case typeField:
+		return Weights{Types: w.Types}
+	case importField:
+		return Weights{Imports: w.Imports}
+	case pkgField:
+		return Weights{Packages: w.Packages}
+	case docField:
+		return Weights{Comments: w.Comments}
+	case structField:
+		return Weights{Fields: w.Fields}
+	case interfaceMethodField:
+		return Weights{InterfaceMethods: w.InterfaceMethods}
+	case constField:
+		return Weights{Consts: w.Consts}
+	case varField:
+		return Weights{Vars: w.Vars}
+	}
+	return w
+}
+
+func (w *Weights) String() string {
+	return fmt.Sprintf("functions=%v,methods=%v,types=%v,imports=%v,packages=%v,comments=%v,fields=%v,interfacemethods=%v,consts=%v,vars=%v",
+		w.Functions, w.Methods, w.Types, w.Imports, w.Packages, w.Comments, w.Fields, w.InterfaceMethods, w.Consts, w.Vars)
+}
+
+// Set parses a comma separated list of name=weight pairs. Weights not listed
+// keep their current value.
+func (w *Weights) Set(s string) error {
+	for _, pair := range strings.Split(s, ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("bad weight %q, want name=weight", pair)
+		}
+		v, err := strconv.ParseFloat(kv[1], 64)
+		if err != nil {
+			return err
+		}
+		switch strings.ToLower(strings.TrimSpace(kv[0])) {
+		case "functions":
+			w.Functions = v
+		case "methods":
+			w.Methods = v
+		case "types":
+			w.Types = v
+		case "imports":
+			w.Imports = v
+		case "packages":
+			w.Packages = v
+		case "comments":
+			w.Comments = v
+		case "fields":
+			w.Fields = v
+		case "interfacemethods":
+			w.InterfaceMethods = v
+		case "consts":
+			w.Consts = v
+		case "vars":
+			w.Vars = v
+		default:
+			return fmt.Errorf("unknown weight %q", kv[0])
+		}
+	}
+	return nil
+}
+
+func termFreq(docTerm *index.DocTerm) float64 {
+	return float64(anyField.count(docTerm))
+}
+
+// ranker looks up a ranker by name, falling back to the one set by flags
+func ranker(name string) (Ranker, error) {
+	if name == "" {
+		name = *defaultRank
+		if *specific {
+			name = "srank"
+		}
+	}
+	r, ok := Rankers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown ranker %q", name)
+	}
+	return r, nil
+}
 
 type Result struct {
-	Context []DocTerm
+	Context []index.DocTerm
 	Rank    float64
     Pack    string
     Path    string
 	Name    string
+	Hits    []Hit //best matching declarations, best first
+	Kind    string //KindPackage, or the kind of Symbol
+	Symbol  *index.Symbol `json:",omitempty"`
+	Module  string        `json:",omitempty"` //module of the package, if any
+	Version string        `json:",omitempty"`
+}
+
+// KindPackage is the Kind of results that are whole packages
+const KindPackage = "package"
+
+// id distinguishes results for symbols in the same package
+func (r *Result) id() string {
+	if r.Symbol != nil {
+		return r.Symbol.ID()
+	}
+	return r.Path
 }
 
 type Results []*Result
 
 func (r Results) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
 func (r Results) Len() int           { return len(r) }
-func (r Results) Less(i, j int) bool { return r[i].Rank < r[j].Rank }
+func (r Results) Less(i, j int) bool {
+	//ties are broken by path so pages are stable from one request to the next
+	if r[i].Rank == r[j].Rank {
+		return r[i].id() > r[j].id()
+	}
+	return r[i].Rank < r[j].Rank
+}
 
 func NewResult() *Result {
-	return &Result{Context: make([]DocTerm, 0), Rank: 0, Name: ""}
+	return &Result{Context: make([]index.DocTerm, 0), Rank: 0, Name: ""}
 }
 
 //map pkg IDS to results
 type ResultMap map[string]*Result
 
-func Run(query string) (Results, error) {
-	resultMap := rankQuery(query)
-	results := sortResults(resultMap)
-	if len(results) > 150 {
-		return results[:150], nil
+// Options control how a query is run
+type Options struct {
+	Ranker  string //name of one of Rankers, empty for the default
+	Weights Weights
+	//KindPackage or a symbol kind to return only that kind of result, empty
+	//for packages and symbols together
+	Kind string
+	//how much the static rank of a result's package counts, see Corpus.boost
+	StaticWeight float64
+	//module path to return only results from that module, empty for all
+	Module string
+	//search production code only, leaving out test files and files excluded
+	//by build constraints
+	Production bool
+
+	//Offset and Limit select a page of results, Cursor continues from the
+	//NextCursor of a previous page and takes precedence over Offset
+	Offset int
+	Limit  int
+	Cursor string
+
+	//statistics of the whole corpus when this index is one shard of it, nil
+	//to score with the statistics of this index alone
+	Global *ShardStats `json:",omitempty"`
+}
+
+// Run parses and evaluates a query, returning the page of results selected by
+// opts, best first. See query.go for the query syntax.
+func Run(query string, opts Options) (Page, error) {
+	t0 := time.Now()
+	results, s, err := evaluate(query, opts)
+	if err != nil {
+		return Page{}, err
+	}
+
+	page, err := Paginate(results, len(results), query, opts)
+	page.Modules = modules(results)
+	for _, r := range page.Results {
+		r.findHits()
+	}
+	if opts.Cursor == "" && opts.Offset == 0 {
+		page.DidYouMean = didYouMean(query, results, s, opts)
 	}
-	return results, nil
+	page.Took = time.Since(t0)
+	return page, err
+}
+
+// evaluate parses and ranks a query against the loaded corpus, returning
+// every result, best first, and the scorer used
+func evaluate(query string, opts Options) (Results, *scorer, error) {
+	q, err := ParseQuery(query)
+	if err != nil {
+		return nil, nil, err
+	}
+	r, err := ranker(opts.Ranker)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch opts.Kind {
+	case "", KindPackage, index.KindFunc, index.KindMethod, index.KindType,
+		index.KindField, index.KindInterfaceMethod, index.KindConst, index.KindVar:
+	default:
+		return nil, nil, fmt.Errorf("unknown kind %q", opts.Kind)
+	}
+	s := newScorer(opts.corpus(), r, opts.Weights, opts.Global, nil)
+	return rank(q, s, opts), s, nil
+}
+
+// corpus returns the loaded corpus, or its production corpus if opts ask for
+// production code only
+func (opts Options) corpus() *Corpus {
+	c := current()
+	if opts.Production {
+		return c.Production
+	}
+	return c
+}
+
+// rank evaluates q and sorts the results, boosted by static rank, best first
+func rank(q Query, s *scorer, opts Options) Results {
+	resultMap := rankQuery(q, s, opts.Kind)
+	for id, r := range resultMap {
+		if pkg := s.c.Index.Packages[r.Path]; pkg != nil {
+			r.Module, r.Version = pkg.Module, pkg.Version
+		}
+		if opts.Module != "" && r.Module != opts.Module {
+			delete(resultMap, id)
+			continue
+		}
+		r.Rank = s.c.boost(r.Rank, r.Path, opts.StaticWeight)
+	}
+	return sortResults(resultMap)
 }
 
 func sortResults(resultMap ResultMap) Results {
 	results := make(Results, len(resultMap))
 
     i := 0
-	for k, v := range resultMap {
+	for _, v := range resultMap {
 		results[i] = v
-		v.Path = k
 		i++
 	}
 	sort.Sort(sort.Reverse(results))
 	return results
 }
 
-func rankQuery(query string) ResultMap {
+// rankQuery evaluates q against packages, symbols or both depending on kind.
+// The results are keyed by package path or symbol ID.
+func rankQuery(q Query, s *scorer, kind string) ResultMap {
 	t0 := time.Now()
-	terms := strings.Fields(strings.ToLower(query))
 	results := make(ResultMap)
-
-	// for each term in query, get its TD-IDF, place that value in Result
-	for _, t := range terms {
-		docMap, ok := index.Index[t]
-		if !ok {
-			continue
+	if kind == "" || kind == KindPackage {
+		for path, r := range q.eval(s) {
+			r.Kind = KindPackage
+			results[path] = r
 		}
-		mapLength := len(docMap)
-		for _, docTerm := range docMap {
-			result, ok := results[docTerm.Path]
-			if !ok {
-				result = NewResult()
-                result.Pack = docTerm.Pack
-				results[docTerm.Path] = result
+	}
+	if kind != KindPackage {
+		for id, r := range q.eval(s.syms) {
+			sym := s.c.Index.Symbols[id]
+			if kind != "" && sym.Kind != kind {
+				continue
 			}
-			result.Rank += score(docTerm, mapLength)
-			result.Context = append(result.Context, *docTerm)
-
-            if result.Name == "" {
-                result.Name = t
-            } else {
-                result.Name += ", " + t
-            }
+			r.Kind = sym.Kind
+			r.Symbol = sym
+			r.Path = sym.Path
+			r.Pack = sym.Pack
+			results[id] = r
 		}
 	}
 
@@ -91,20 +395,60 @@ func rankQuery(query string) ResultMap {
 	return results
 }
 
-func score(docTerm *DocTerm, mapLength int) float64 {
-	importmult := 1.0
-	if *specific {
-		docTerm.Functions *= 4
-		docTerm.Types *= 2
-		docTerm.Packages *= 1
-		importmult = 0.5
+// A scorer evaluates a query against a corpus with a particular ranker and
+// set of weights
+type scorer struct {
+	c       *Corpus
+	ranker  Ranker
+	weights Weights
+	//scores the symbols of c
+	syms *scorer
+	//document frequencies across every shard, nil unless sharded
+	df map[string]int
+	//if not nil, records the local document frequency of each term looked up
+	seen map[string]int
+}
+
+// newScorer returns a scorer for c and its symbols. If global is not nil, c is
+// one shard of a larger corpus and terms are scored with the statistics of the
+// whole. If seen is not nil the document frequency of every term looked up is
+// recorded in it.
+func newScorer(c *Corpus, r Ranker, w Weights, global, seen *ShardStats) *scorer {
+	s := &scorer{c: c, ranker: r, weights: w}
+	s.syms = &scorer{c: c.Symbols, ranker: r, weights: w}
+	if global != nil {
+		s.c, s.df = global.Packages.apply(c)
+		s.syms.c, s.syms.df = global.Symbols.apply(c.Symbols)
+	}
+	if seen != nil {
+		s.seen, s.syms.seen = seen.Packages.DF, seen.Symbols.DF
+	}
+	return s
+}
+
+// postings looks up the postings of term and the document frequency to score
+// them with
+func (s *scorer) postings(term string) (index.DocMap, int) {
+	docMap := s.c.Index.Index[term]
+	if s.seen != nil {
+		s.seen[term] = len(docMap)
+	}
+	if df, ok := s.df[term]; ok {
+		return docMap, df
 	}
-	freq := float64(docTerm.Functions)
-	freq += float64(docTerm.Imports) * importmult
-	freq += float64(docTerm.Packages)
-	freq += float64(docTerm.Types)
+	return docMap, len(docMap)
+}
 
-	tfidf := freq * math.Log(float64(index.UniquePkgs)/float64(mapLength))
-	return tfidf
+// known is Corpus.known, except that with global statistics a word indexed by
+// any shard is known
+func (s *scorer) known(word string) bool {
+	if s.df[word] > 0 || s.df[s.c.commentTerm(word)] > 0 {
+		return true
+	}
+	return s.c.known(word)
+}
 
+// score rates a term's occurrences in field f of a package
+func (s *scorer) score(docTerm *index.DocTerm, df int, f field) float64 {
+	return s.ranker.Score(*docTerm, df, s.c, s.weights.only(f))
 }
@@ -0,0 +1,60 @@
+package search
+
+import (
+	"errors"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"go-search/index"
+)
+
+var (
+	loading    sync.Mutex
+	loadedFile string //index file of the loaded corpus, guarded by loading
+)
+
+// Reload loads the index file opened by OpenIndex again and swaps it in
+// when it is ready. Queries run against the old index in the meantime, and
+// keep doing so if the file fails to load.
+func Reload() (index.Header, error) {
+	loading.Lock()
+	file := loadedFile
+	loading.Unlock()
+	if file == "" {
+		return index.Header{}, errors.New("no index has been opened")
+	}
+	return load(file)
+}
+
+// Watch reloads the index whenever its file changes, checking every interval.
+// The parser replaces the file by renaming a complete one over it, so a
+// change is never seen half written. Failed reloads are logged.
+func Watch(interval time.Duration) {
+	last := indexStamp()
+	for range time.Tick(interval) {
+		stamp := indexStamp()
+		if stamp == last || stamp == (index.FileStamp{}) {
+			continue
+		}
+		last = stamp
+		log.Println("Index file changed, reloading")
+		if _, err := Reload(); err != nil {
+			log.Println("Reload failed:", err)
+		}
+	}
+}
+
+// indexStamp returns the modification time and size of the loaded index file,
+// or a zero stamp if it can't be read
+func indexStamp() index.FileStamp {
+	loading.Lock()
+	file := loadedFile
+	loading.Unlock()
+	fi, err := os.Stat(file)
+	if err != nil {
+		return index.FileStamp{}
+	}
+	return index.FileStamp{ModTime: fi.ModTime().UnixNano(), Size: fi.Size()}
+}
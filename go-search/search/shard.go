@@ -0,0 +1,107 @@
+package search
+
+import (
+	"fmt"
+	"time"
+)
+
+// When the corpus is split across shard servers, each shard only knows how
+// common a term is among its own packages. A query is run in two rounds: the
+// coordinator first collects the statistics of the query's terms from every
+// shard with QueryStats, sums them, and then has each shard score its
+// packages against the sums with Top, so a package scores the same whichever
+// shard holds it. The static rank of a package depends on the whole import
+// graph rather than on the query, so the parser computes it once for every
+// package when it writes the shards, see index.Index.Ranks.
+
+// CorpusStats are the statistics of a corpus a score depends on beyond the
+// package being scored
+type CorpusStats struct {
+	Docs     int
+	TotalLen int            //total term occurrences over all documents
+	DF       map[string]int //document frequency of each term a query looked up
+}
+
+// ShardStats are the statistics of a shard's packages and of its symbols
+type ShardStats struct {
+	Packages CorpusStats
+	Symbols  CorpusStats
+}
+
+// Add sums the statistics of another shard into s
+func (s *ShardStats) Add(o ShardStats) {
+	s.Packages.add(o.Packages)
+	s.Symbols.add(o.Symbols)
+}
+
+func (s *CorpusStats) add(o CorpusStats) {
+	s.Docs += o.Docs
+	s.TotalLen += o.TotalLen
+	if s.DF == nil {
+		s.DF = make(map[string]int)
+	}
+	for term, df := range o.DF {
+		s.DF[term] += df
+	}
+}
+
+// apply returns a copy of c that scores with these statistics, along with the
+// document frequencies to look terms up in
+func (s *CorpusStats) apply(c *Corpus) (*Corpus, map[string]int) {
+	g := *c
+	g.Docs = s.Docs
+	if s.Docs > 0 {
+		g.AvgDocLen = float64(s.TotalLen) / float64(s.Docs)
+	}
+	return &g, s.DF
+}
+
+// stats returns the statistics of c, without any document frequencies
+func (c *Corpus) stats() CorpusStats {
+	return CorpusStats{Docs: c.Docs, TotalLen: c.TotalLen, DF: make(map[string]int)}
+}
+
+// QueryStats evaluates query against the loaded corpus and returns its
+// statistics, with the document frequency of every term the query looks up.
+// opts.Global is ignored.
+func QueryStats(query string, opts Options) (ShardStats, error) {
+	q, err := ParseQuery(query)
+	if err != nil {
+		return ShardStats{}, err
+	}
+	r, err := ranker(opts.Ranker)
+	if err != nil {
+		return ShardStats{}, err
+	}
+	c := opts.corpus()
+	stats := ShardStats{Packages: c.stats(), Symbols: c.Symbols.stats()}
+	rankQuery(q, newScorer(c, r, opts.Weights, nil, &stats), opts.Kind)
+	return stats, nil
+}
+
+// Top evaluates query like Run but returns the best k results, unpaginated,
+// for a coordinator to merge with those of other shards. Total still counts
+// every result. k must be positive.
+func Top(query string, opts Options, k int) (Page, error) {
+	if k <= 0 {
+		return Page{}, fmt.Errorf("bad k %v, want at least 1", k)
+	}
+	t0 := time.Now()
+	results, s, err := evaluate(query, opts)
+	if err != nil {
+		return Page{}, err
+	}
+	page := Page{Total: len(results), Modules: modules(results)}
+	if k < len(results) {
+		results = results[:k]
+	}
+	for _, r := range results {
+		r.findHits()
+	}
+	page.Results = results
+	if opts.Cursor == "" && opts.Offset == 0 {
+		page.DidYouMean = didYouMean(query, results, s, opts)
+	}
+	page.Took = time.Since(t0)
+	return page, nil
+}
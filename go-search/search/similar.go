@@ -0,0 +1,153 @@
+package search
+
+import (
+	"math"
+	"sort"
+
+	"go-search/index"
+)
+
+const (
+	DefaultSimilar = 10
+	MaxSimilar     = 100
+)
+
+// A package's term vector weighs each of its terms by TF-IDF: the number of
+// times the package uses the term times the log of the inverse fraction of
+// packages using it. Two packages are similar if their vectors point the same
+// way, whatever their size.
+
+// A TermWeight is one term of a package's term vector
+type TermWeight struct {
+	Term   string
+	Count  int //occurrences of the term in the package
+	Weight float64
+}
+
+// Counts are the occurrences of a package's terms in each kind of declaration
+type Counts struct {
+	Functions        int
+	Methods          int
+	Types            int
+	Imports          int
+	Packages         int
+	Comments         int
+	Fields           int
+	InterfaceMethods int
+	Consts           int
+	Vars             int
+}
+
+func (c *Counts) add(d *index.DocTerm) {
+	c.Functions += d.Functions
+	c.Methods += d.Methods
+	c.Types += d.Types
+	c.Imports += d.Imports
+	c.Packages += d.Packages
+	c.Comments += d.Comments
+	c.Fields += d.Fields
+	c.InterfaceMethods += d.InterfaceMethods
+	c.Consts += d.Consts
+	c.Vars += d.Vars
+}
+
+// A PackageDetail is what the index knows about one package: its record, the
+// counts of its terms by kind and its whole term vector, heaviest term first
+type PackageDetail struct {
+	*index.Package
+	Counts Counts
+	Terms  []TermWeight
+}
+
+// A SimilarPackage is a package and the cosine similarity of its term vector
+// to that of the package it was compared with, between 0 and 1
+type SimilarPackage struct {
+	*index.Package
+	Similarity float64
+}
+
+// Detail returns the detail of the package at path, or nil if it isn't indexed
+func Detail(path string) *PackageDetail {
+	c := current()
+	pkg := c.Index.Packages[path]
+	if pkg == nil {
+		return nil
+	}
+	detail := &PackageDetail{Package: pkg, Terms: []TermWeight{}}
+	for term, docMap := range c.Index.Index {
+		docTerm, ok := docMap[path]
+		if !ok {
+			continue
+		}
+		detail.Counts.add(docTerm)
+		detail.Terms = append(detail.Terms, TermWeight{term, int(termFreq(docTerm)), c.tfidf(docTerm, len(docMap))})
+	}
+	sort.Slice(detail.Terms, func(i, j int) bool {
+		ti, tj := detail.Terms[i], detail.Terms[j]
+		if ti.Weight != tj.Weight {
+			return ti.Weight > tj.Weight
+		}
+		return ti.Term < tj.Term
+	})
+	return detail
+}
+
+// Similar ranks the other indexed packages by the cosine similarity of their
+// term vectors to that of the package at path, most similar first, and
+// returns up to limit of them. Packages sharing no terms with it are left out.
+// It returns nil if path isn't indexed.
+func Similar(path string, limit int) []SimilarPackage {
+	if limit <= 0 {
+		limit = DefaultSimilar
+	}
+	if limit > MaxSimilar {
+		limit = MaxSimilar
+	}
+	c := current()
+	if c.Index.Packages[path] == nil {
+		return nil
+	}
+	//only the postings of the package's own terms can add to a dot product
+	dot := make(map[string]float64)
+	for _, docMap := range c.Index.Index {
+		docTerm, ok := docMap[path]
+		if !ok {
+			continue
+		}
+		w := c.tfidf(docTerm, len(docMap))
+		if w == 0 {
+			continue
+		}
+		for other, d := range docMap {
+			if other != path {
+				dot[other] += w * c.tfidf(d, len(docMap))
+			}
+		}
+	}
+
+	similar := []SimilarPackage{}
+	norm := c.Norms[path]
+	for other, product := range dot {
+		pkg := c.Index.Packages[other]
+		if product == 0 || pkg == nil {
+			continue
+		}
+		similar = append(similar, SimilarPackage{pkg, product / (norm * c.Norms[other])})
+	}
+	sort.Slice(similar, func(i, j int) bool {
+		if similar[i].Similarity != similar[j].Similarity {
+			return similar[i].Similarity > similar[j].Similarity
+		}
+		return similar[i].Path < similar[j].Path
+	})
+	if len(similar) > limit {
+		similar = similar[:limit]
+	}
+	return similar
+}
+
+// tfidf weighs one term of a package's term vector, given the number of
+// packages using the term
+func (c *Corpus) tfidf(docTerm *index.DocTerm, df int) float64 {
+	return termFreq(docTerm) * math.Log(float64(c.Docs)/float64(df))
+}
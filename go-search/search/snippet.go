@@ -0,0 +1,93 @@
+package search
+
+import (
+	"sort"
+	"strings"
+
+	"go-search/index"
+)
+
+// maxHits is the number of declarations returned with each result
+const maxHits = 5
+
+// A Hit is a declaration in a result's package that matched the query
+type Hit struct {
+	index.Loc
+	//byte offsets of the matched terms in Snippet, as [start, end) pairs
+	Highlights [][2]int
+	matched    int
+}
+
+// findHits picks the declarations that match the most query terms out of the
+// locations recorded for each term in the result's context
+func (r *Result) findHits() {
+	terms := make([]string, 0, len(r.Context))
+	byPos := make(map[index.Loc]*Hit)
+	for _, docTerm := range r.Context {
+		terms = append(terms, docTerm.Term)
+		for _, l := range docTerm.Locs {
+			h, ok := byPos[l]
+			if !ok {
+				h = &Hit{Loc: l}
+				byPos[l] = h
+			}
+			h.matched++
+		}
+	}
+
+	hits := make([]*Hit, 0, len(byPos))
+	for _, h := range byPos {
+		hits = append(hits, h)
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].matched != hits[j].matched {
+			return hits[i].matched > hits[j].matched
+		}
+		if hits[i].File != hits[j].File {
+			return hits[i].File < hits[j].File
+		}
+		return hits[i].Line < hits[j].Line
+	})
+	if len(hits) > maxHits {
+		hits = hits[:maxHits]
+	}
+
+	r.Hits = make([]Hit, len(hits))
+	for i, h := range hits {
+		h.Highlights = highlight(h.Snippet, terms)
+		r.Hits[i] = *h
+	}
+}
+
+// highlight finds every case-insensitive occurrence of the terms in snippet,
+// merging ranges that overlap
+func highlight(snippet string, terms []string) [][2]int {
+	lower := strings.ToLower(snippet)
+	var spans [][2]int
+	for _, t := range terms {
+		if t == "" {
+			continue
+		}
+		for from := 0; ; {
+			i := strings.Index(lower[from:], t)
+			if i < 0 {
+				break
+			}
+			spans = append(spans, [2]int{from + i, from + i + len(t)})
+			from += i + len(t)
+		}
+	}
+	sort.Slice(spans, func(i, j int) bool { return spans[i][0] < spans[j][0] })
+
+	merged := make([][2]int, 0, len(spans))
+	for _, s := range spans {
+		if n := len(merged); n > 0 && s[0] <= merged[n-1][1] {
+			if s[1] > merged[n-1][1] {
+				merged[n-1][1] = s[1]
+			}
+			continue
+		}
+		merged = append(merged, s)
+	}
+	return merged
+}
@@ -0,0 +1,69 @@
+package search
+
+import (
+	"sort"
+	"strings"
+)
+
+const (
+	DefaultSuggestions = 10
+	MaxSuggestions     = 50
+)
+
+// A Completion is an indexed term offered as the user types
+type Completion struct {
+	Term string
+	Kind string //the field prefix of the kind of declaration it occurs as most, e.g. func
+	DF   int    //number of packages the term occurs in
+}
+
+// completions builds the dictionary of terms to complete, sorted by term.
+// Terms only found in comments are left out, as they may be stems.
+func (c *Corpus) completions() []Completion {
+	var dict []Completion
+	for term, docMap := range c.Index.Index {
+		var counts [docField]int
+		for _, docTerm := range docMap {
+			for f := funcField; f < docField; f++ {
+				counts[f] += f.count(docTerm)
+			}
+		}
+		best := anyField
+		for f := funcField; f < docField; f++ {
+			if counts[f] > counts[best] {
+				best = f
+			}
+		}
+		if best == anyField {
+			continue
+		}
+		dict = append(dict, Completion{term, strings.TrimSuffix(best.prefix(), ":"), len(docMap)})
+	}
+	sort.Slice(dict, func(i, j int) bool { return dict[i].Term < dict[j].Term })
+	return dict
+}
+
+// Suggest returns up to limit completions of prefix, the most widely used
+// first
+func Suggest(prefix string, limit int) []Completion {
+	if limit <= 0 {
+		limit = DefaultSuggestions
+	}
+	if limit > MaxSuggestions {
+		limit = MaxSuggestions
+	}
+	prefix = strings.ToLower(prefix)
+	dict := current().Completions
+	start := sort.Search(len(dict), func(i int) bool { return dict[i].Term >= prefix })
+	end := start + sort.Search(len(dict)-start, func(i int) bool {
+		return !strings.HasPrefix(dict[start+i].Term, prefix)
+	})
+
+	matches := make([]Completion, end-start)
+	copy(matches, dict[start:end])
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].DF > matches[j].DF })
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+	return matches
+}
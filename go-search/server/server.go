@@ -1,25 +1,71 @@
 // This package implements a simple HTTP server providing a REST API to a task handler.
 //
-// It provides four methods:
+// It provides these methods:
 //
 // 	GET    /search/        Start query and return results
+// 	GET    /packages/{path}  Describe an indexed package and its terms
+// 	GET    /packages/{path}/importers  List the packages importing path
+// 	GET    /packages/{path}/similar  List the packages most like path
+// 	GET    /suggest?prefix=  Complete a partly typed term
+// 	GET    /implements?iface=  List the types implementing an interface
+// 	POST   /admin/reload     Reload the index file
+// 	POST   /shard/stats, /shard/top  Answer a cluster coordinator, see go-search/cluster
 // Every method below gives more information about every API call, its parameters, and its results.
 
 package server
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"strconv"
+	"time"
 
+	"go-search/cluster"
+	"go-search/index"
 	"go-search/search"
 
 	"github.com/gorilla/mux"
 )
 
-const PathPrefix = "/search/"
+const (
+	PathPrefix     = "/search/"
+	PackagesPrefix = "/packages/"
+	SuggestPath    = "/suggest"
+	ImplementsPath = "/implements"
+	AdminPrefix    = "/admin/"
+	ShardPrefix    = "/shard/"
+)
+
+// run evaluates searches, against the local index unless RegisterCoordinator
+// has been called
+var run = search.Run
 
 func RegisterHandlers() {
+	r := mux.NewRouter()
+	r.HandleFunc(PathPrefix, errorHandler(NewSearch)).Methods("POST")
+	r.HandleFunc(PackagesPrefix+"{path:.+}/importers", errorHandler(GetImporters)).Methods("GET")
+	r.HandleFunc(PackagesPrefix+"{path:.+}/similar", errorHandler(GetSimilar)).Methods("GET")
+	r.HandleFunc(PackagesPrefix+"{path:.+}", errorHandler(GetPackage)).Methods("GET")
+	r.HandleFunc(SuggestPath, errorHandler(GetSuggestions)).Methods("GET")
+	r.HandleFunc(ImplementsPath, errorHandler(GetImplements)).Methods("GET")
+	r.HandleFunc(AdminPrefix+"reload", errorHandler(PostReload)).Methods("POST")
+	r.HandleFunc(cluster.StatsPath, errorHandler(PostShardStats)).Methods("POST")
+	r.HandleFunc(cluster.TopPath, errorHandler(PostShardTop)).Methods("POST")
+	http.Handle(PathPrefix, r)
+	http.Handle(PackagesPrefix, r)
+	http.Handle(SuggestPath, r)
+	http.Handle(ImplementsPath, r)
+	http.Handle(AdminPrefix, r)
+	http.Handle(ShardPrefix, r)
+}
+
+// RegisterCoordinator serves /search/ by fanning queries out to the shards of
+// a cluster. The other endpoints need an index of their own and aren't served.
+func RegisterCoordinator(co *cluster.Coordinator) {
+	run = co.Search
 	r := mux.NewRouter()
 	r.HandleFunc(PathPrefix, errorHandler(NewSearch)).Methods("POST")
 	http.Handle(PathPrefix, r)
@@ -28,6 +74,16 @@ func RegisterHandlers() {
 // badRequest is handled by setting the status code in the reply to StatusBadRequest.
 type badRequest  struct { error }
 
+// forbidden is handled by setting the status code in the reply to StatusForbidden.
+type forbidden struct{ error }
+
+// notFound is handled by setting the status code in the reply to StatusNotFound.
+type notFound struct{ error }
+
+// serverError is handled by setting the status code in the reply to
+// StatusInternalServerError. Unlike other errors its message is sent as well.
+type serverError struct{ error }
+
 // errorHandler wraps a function returning an error by handling the error and returning a http.Handler.
 // If the error is of the one of the types defined above, it is handled as described for every type.
 // If the error is of another type, it is considered as an internal error and its message is logged.
@@ -40,6 +96,13 @@ func errorHandler(f func(w http.ResponseWriter, r *http.Request) error) http.Han
 		switch err.(type) {
 		case badRequest:
 			http.Error(w, err.Error(), http.StatusBadRequest)
+		case notFound:
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case forbidden:
+			http.Error(w, err.Error(), http.StatusForbidden)
+		case serverError:
+			log.Println(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
 		default:
 			log.Println(err)
 			http.Error(w, "oops", http.StatusInternalServerError)
@@ -48,22 +111,82 @@ func errorHandler(f func(w http.ResponseWriter, r *http.Request) error) http.Han
 }
 
 // NewSearch handles GET requests on /search.
-// The request body must contain a JSON object with a Title field.
+// The request body must contain a JSON object with a Query field, written in
+// the syntax described in go-search/search/query.go, and may name one of
+// search.Rankers in a Ranker field. Weights for each kind of declaration may
+// be given in a Weights object; any left out default to search.DefaultWeights.
+// Kind may be "package", "func", "method", "type", "field", "imethod", "const"
+// or "var" to return only packages or only symbols of that kind; by default
+// both are returned, ranked together.
+// StaticWeight sets how much a package's PageRank over the import graph counts
+// towards its score, and defaults to search.DefaultStaticWeight. Module limits
+// results to packages of the module with that path. Production leaves out
+// test files and files excluded by the indexer's build constraints; hits in
+// them are otherwise marked with an Origin of "test" or "ignored".
+// Symbols carry the Example functions from the package's tests that document
+// them in Examples.
+// A query may search for functions and methods by signature, as in
+// func(io.Reader) ([]byte, error). They rank highest when their parameter and
+// result types match exactly and in order, and lower when they match only in
+// another order or take more general types such as any or type parameters.
+// A query that doesn't parse or an unknown ranker or kind is a bad request.
+// Behind a cluster coordinator, a shard that fails to answer is an internal
+// error.
+//
+// Results are paged: Offset and Limit pick a page, or Cursor may be set to
+// the NextCursor of the previous page. An Offset past search.MaxOffset is a
+// bad request. Total counts every matching package,
+// Took is the time spent on the query in milliseconds, and NextCursor is
+// empty on the last page. Modules counts the results from each module, for
+// grouping or narrowing them down by module.
+//
+// Misspelled words match the indexed words closest to them at reduced weight.
+// When correcting them ranks much better, the first page carries the
+// corrected query in DidYouMean.
 // The status code of the response is used to indicate any error.
 //
 // Examples:
 //
-//   req: POST /search/ {"Query": ""}
+//   req: POST /search/ {"Query": "import:net/http AND type:router", "Ranker": "bm25",
+//                       "Weights": {"Functions": 4, "Imports": 0.5}, "Limit": 20}
 //   res: 200 {"Results": [
-//          {"Title": "Example Code Package", "Path": "example.com"},
-//          {"Title": "Example Code Package", "Path": "example.com"},
-//        ]}
+//          {"Pack": "mux", "Path": "github.com/gorilla/mux", "Rank": 12.5, ...},
+//          {"Pack": "pat", "Path": "github.com/bmizerany/pat", "Rank": 9.1, ...},
+//          {"Kind": "type", "Symbol": {"Name": "Router", "Signature": "type Router struct{...}", ...}, ...},
+//        ], "Total": 57, "Took": 1.6, "NextCursor": "MjAuMWE0YjNjMmQ",
+//        "Modules": [{"Module": "github.com/gorilla/mux", "Count": 6}, ...]}
 func NewSearch(w http.ResponseWriter, r *http.Request) error {
-	req := struct{ Query string }{}
+	req := struct {
+		Query   string
+		Ranker  string
+		Weights search.Weights
+		Kind    string
+		Offset  int
+		Limit   int
+		Cursor  string
+		Module  string
+
+		Production   bool
+		StaticWeight float64
+	}{Weights: search.DefaultWeights, StaticWeight: search.DefaultStaticWeight}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		return badRequest{err}
 	}
-	results, err := search.Run(req.Query)
+	page, err := run(req.Query, search.Options{
+		Ranker:  req.Ranker,
+		Weights: req.Weights,
+		Kind:    req.Kind,
+		Offset:  req.Offset,
+		Limit:   req.Limit,
+		Cursor:  req.Cursor,
+		Module:  req.Module,
+
+		Production:   req.Production,
+		StaticWeight: req.StaticWeight,
+	})
+	if _, ok := err.(*cluster.ShardError); ok {
+		return err
+	}
 	if err != nil {
 		return badRequest{err}
 	}
@@ -71,14 +194,236 @@ func NewSearch(w http.ResponseWriter, r *http.Request) error {
     //Results come back as pointers to the structs
     //  Need them as actual values for JSON
 
-    var res = make([]search.Result, len(results))
+    var res = make([]search.Result, len(page.Results))
     
     i := 0
-    for _, v := range results {
+    for _, v := range page.Results {
         res[i] = *v
         i++
     }
 
-	ret := struct{ Results []search.Result }{res}
+	ret := struct {
+		Results    []search.Result
+		Total      int
+		Took       float64
+		NextCursor string
+		DidYouMean string `json:",omitempty"`
+		Modules    []search.ModuleCount
+	}{res, page.Total, float64(page.Took) / float64(time.Millisecond), page.NextCursor, page.DidYouMean, page.Modules}
+	return json.NewEncoder(w).Encode(ret)
+}
+
+// GetImporters handles GET requests on /packages/{path}/importers.
+// It lists the indexed packages that import path, which need not be indexed
+// itself. A path that is neither indexed nor imported is not found.
+//
+// Examples:
+//
+//   req: GET /packages/net/http/importers
+//   res: 200 {"Path": "net/http", "Count": 2, "Importers": [
+//          {"Name": "mux", "Path": "github.com/gorilla/mux", "Imports": [...]},
+//          {"Name": "pat", "Path": "github.com/bmizerany/pat", "Imports": [...]},
+//        ]}
+func GetImporters(w http.ResponseWriter, r *http.Request) error {
+	path := mux.Vars(r)["path"]
+	importers := search.Importers(path)
+	if importers == nil && search.Package(path) == nil {
+		return notFound{fmt.Errorf("unknown package %q", path)}
+	}
+	ret := struct {
+		Path      string
+		Count     int
+		Importers []*index.Package
+	}{path, len(importers), importers}
+	if ret.Importers == nil {
+		ret.Importers = []*index.Package{}
+	}
+	return json.NewEncoder(w).Encode(ret)
+}
+
+// GetPackage handles GET requests on /packages/{path}.
+// It returns the indexed package at path with its doc synopsis and imports,
+// how often its terms occur in each kind of declaration, and its whole term
+// vector, each term with its count and TF-IDF weight, heaviest first. Test
+// files count towards it. A path that isn't indexed is not found.
+//
+// Examples:
+//
+//   req: GET /packages/github.com/gorilla/mux
+//   res: 200 {"Name": "mux", "Path": "github.com/gorilla/mux",
+//          "Synopsis": "Package mux implements a request router and dispatcher.",
+//          "Imports": ["bytes", "context", ...],
+//          "Counts": {"Functions": 61, "Methods": 140, "Types": 38, ...},
+//          "Terms": [{"Term": "route", "Count": 212, "Weight": 310.4}, ...]}
+func GetPackage(w http.ResponseWriter, r *http.Request) error {
+	path := mux.Vars(r)["path"]
+	detail := search.Detail(path)
+	if detail == nil {
+		return notFound{fmt.Errorf("unknown package %q", path)}
+	}
+	return json.NewEncoder(w).Encode(detail)
+}
+
+// GetSimilar handles GET requests on /packages/{path}/similar.
+// It lists the other indexed packages by the cosine similarity of their TF-IDF
+// term vectors to that of path, most similar first, to find alternatives to
+// a package. The optional n parameter sets how many are returned, by default
+// search.DefaultSimilar. A path that isn't indexed is not found, a bad n is a
+// bad request.
+//
+// Examples:
+//
+//   req: GET /packages/github.com/gorilla/mux/similar?n=2
+//   res: 200 {"Path": "github.com/gorilla/mux", "Similar": [
+//          {"Name": "pat", "Path": "github.com/bmizerany/pat", "Similarity": 0.62, ...},
+//          {"Name": "httprouter", "Path": "github.com/julienschmidt/httprouter", "Similarity": 0.58, ...},
+//        ]}
+func GetSimilar(w http.ResponseWriter, r *http.Request) error {
+	path := mux.Vars(r)["path"]
+	n := 0
+	if s := r.FormValue("n"); s != "" {
+		var err error
+		if n, err = strconv.Atoi(s); err != nil {
+			return badRequest{fmt.Errorf("bad n %q", s)}
+		}
+	}
+	similar := search.Similar(path, n)
+	if similar == nil {
+		return notFound{fmt.Errorf("unknown package %q", path)}
+	}
+	ret := struct {
+		Path    string
+		Similar []search.SimilarPackage
+	}{path, similar}
 	return json.NewEncoder(w).Encode(ret)
 }
+
+// GetSuggestions handles GET requests on /suggest.
+// It completes the prefix query parameter to the indexed identifiers, package
+// names and import paths starting with it, those used by the most packages
+// first. The optional n parameter sets how many are returned, by default
+// search.DefaultSuggestions. A missing prefix or bad n is a bad request.
+//
+// Examples:
+//
+//   req: GET /suggest?prefix=rout&n=3
+//   res: 200 {"Prefix": "rout", "Suggestions": [
+//          {"Term": "router", "Kind": "type", "DF": 14},
+//          {"Term": "route", "Kind": "func", "DF": 9},
+//          {"Term": "routes", "Kind": "func", "DF": 3},
+//        ]}
+func GetSuggestions(w http.ResponseWriter, r *http.Request) error {
+	prefix := r.FormValue("prefix")
+	if prefix == "" {
+		return badRequest{fmt.Errorf("missing prefix")}
+	}
+	n := 0
+	if s := r.FormValue("n"); s != "" {
+		var err error
+		if n, err = strconv.Atoi(s); err != nil {
+			return badRequest{fmt.Errorf("bad n %q", s)}
+		}
+	}
+	ret := struct {
+		Prefix      string
+		Suggestions []search.Completion
+	}{prefix, search.Suggest(prefix, n)}
+	return json.NewEncoder(w).Encode(ret)
+}
+
+// GetImplements handles GET requests on /implements.
+// It lists the exported concrete types of the indexed packages that implement
+// the interface named by the iface query parameter, as import path and name.
+// Pointer is set on types where only the pointer has all of the methods.
+// Method sets are only known when the parser was run with -types, and then
+// only for interfaces declared or imported by indexed packages; any other
+// iface is not found. A missing iface or one that isn't an interface is a
+// bad request.
+//
+// Examples:
+//
+//   req: GET /implements?iface=net/http.Handler
+//   res: 200 {"Interface": "net/http.Handler", "Count": 2, "Types": [
+//          {"Name": "Router", "Path": "github.com/gorilla/mux", "Pointer": true},
+//          {"Name": "PatternServeMux", "Path": "github.com/bmizerany/pat", "Pointer": true},
+//        ]}
+func GetImplements(w http.ResponseWriter, r *http.Request) error {
+	id := r.FormValue("iface")
+	if id == "" {
+		return badRequest{fmt.Errorf("missing iface")}
+	}
+	iface := search.NamedType(id)
+	if iface == nil {
+		return notFound{fmt.Errorf("unknown interface %q", id)}
+	}
+	if !iface.Interface {
+		return badRequest{fmt.Errorf("%q is not an interface", id)}
+	}
+	impls := search.Implementations(iface)
+	ret := struct {
+		Interface string
+		Count     int
+		Types     []search.Implementation
+	}{id, len(impls), impls}
+	return json.NewEncoder(w).Encode(ret)
+}
+
+// PostReload handles POST requests on /admin/reload.
+// It reloads the index file in the background of running queries, which
+// finish against the old index, and swaps the new one in once it is ready.
+// Only requests from the loopback interface are allowed. If the file fails to
+// load the old index is kept and the reply is an internal error carrying the
+// reason, such as a checksum or schema version mismatch.
+//
+// Examples:
+//
+//   req: POST /admin/reload
+//   res: 200 {"Created": "2015-03-02T10:04:11Z", "Stats": {"Terms": 48213, ...}, "Took": 840.2}
+func PostReload(w http.ResponseWriter, r *http.Request) error {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if ip := net.ParseIP(host); err != nil || ip == nil || !ip.IsLoopback() {
+		return forbidden{fmt.Errorf("reload is only allowed from localhost")}
+	}
+	t0 := time.Now()
+	hdr, err := search.Reload()
+	if err != nil {
+		return serverError{err}
+	}
+	ret := struct {
+		Created time.Time
+		Stats   index.Stats
+		Took    float64
+	}{hdr.Created, hdr.Stats, float64(time.Since(t0)) / float64(time.Millisecond)}
+	return json.NewEncoder(w).Encode(ret)
+}
+
+// PostShardStats handles POST requests on /shard/stats from a cluster
+// coordinator. The body is a cluster.StatsRequest, the reply the
+// search.ShardStats of the query against this server's index.
+func PostShardStats(w http.ResponseWriter, r *http.Request) error {
+	var req cluster.StatsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return badRequest{err}
+	}
+	stats, err := search.QueryStats(req.Query, req.Options)
+	if err != nil {
+		return badRequest{err}
+	}
+	return json.NewEncoder(w).Encode(stats)
+}
+
+// PostShardTop handles POST requests on /shard/top from a cluster
+// coordinator. The body is a cluster.TopRequest, the reply a search.Page of
+// this server's best K results, scored with the global statistics sent. A K
+// below 1 is a bad request.
+func PostShardTop(w http.ResponseWriter, r *http.Request) error {
+	var req cluster.TopRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return badRequest{err}
+	}
+	page, err := search.Top(req.Query, req.Options, req.K)
+	if err != nil {
+		return badRequest{err}
+	}
+	return json.NewEncoder(w).Encode(page)
+}
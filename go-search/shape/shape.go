@@ -0,0 +1,513 @@
+// Package shape normalises the parameter and result types of functions,
+// so that a function can be found by the shape of its signature. The parser
+// records the signature of every function and method it indexes and the
+// query parser parses signature queries such as func(io.Reader) ([]byte, error)
+// with it, so the two are always written the same way.
+//
+// Types are written the way go/printer prints them with these changes: types
+// declared by the function's own package are qualified by the package name,
+// imported types by the last element of their import path whatever the file
+// imports them as, byte and rune are uint8 and int32, interface{} is any, a
+// variadic ...T is []T and type parameters are numbered $0, $1 and so on in
+// the order they are declared.
+//
+// A type parameter matches any type its constraint allows, and the same type
+// wherever it appears in the signature. A constraint's type set is only known
+// when it is any, comparable, a union such as ~int | ~int64 or one of the
+// well known constraints of cmp and golang.org/x/exp/constraints. Other
+// interfaces allow any type, as their methods can't be checked without type
+// information.
+package shape
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// A Signature is the normalised types of a function's parameters and results,
+// one per parameter or result, without the receiver of a method
+type Signature struct {
+	Params  []string
+	Results []string
+	//the constraint of each type parameter, $0 first; "" where it isn't
+	//known, as for those of a generic receiver
+	TypeParams []string
+}
+
+// Of normalises the signature of decl, a function of the package named pack.
+// imports maps the names the file refers to its imports by to the package
+// names, see Imports.
+func Of(decl *ast.FuncDecl, pack string, imports map[string]string) Signature {
+	n := &normaliser{pack: pack, imports: imports, tparams: make(map[string]string)}
+	sig := Signature{}
+	n.constraints = &sig.TypeParams
+	if decl.Recv != nil && len(decl.Recv.List) == 1 {
+		//the type parameters of a generic receiver, e.g. T in (l *List[T])
+		recv := decl.Recv.List[0].Type
+		if star, ok := recv.(*ast.StarExpr); ok {
+			recv = star.X
+		}
+		switch x := recv.(type) {
+		case *ast.IndexExpr:
+			n.declare(x.Index, nil)
+		case *ast.IndexListExpr:
+			for _, e := range x.Indices {
+				n.declare(e, nil)
+			}
+		}
+	}
+	n.signature(decl.Type, &sig)
+	return sig
+}
+
+// Parse parses a signature query such as func(string) (int, error). Names are
+// left as written, so they must be written as Of writes them: a query for
+// func(ioutil.Reader) finds nothing.
+func Parse(query string) (Signature, error) {
+	e, err := parser.ParseExpr(query)
+	if err != nil {
+		return Signature{}, fmt.Errorf("bad signature %s: %v", query, err)
+	}
+	ft, ok := e.(*ast.FuncType)
+	if !ok {
+		return Signature{}, fmt.Errorf("bad signature %s: want a func type", query)
+	}
+	n := &normaliser{tparams: make(map[string]string)}
+	var sig Signature
+	n.signature(ft, &sig)
+	return sig, nil
+}
+
+// Imports maps the names a file refers to its imports by to the names of the
+// imported packages, taken to be the last element of their paths. Blank and
+// dot imports are left out.
+func Imports(f *ast.File) map[string]string {
+	imports := make(map[string]string)
+	for _, spec := range f.Imports {
+		p, err := strconv.Unquote(spec.Path.Value)
+		if err != nil {
+			continue
+		}
+		name := path.Base(p)
+		local := name
+		if spec.Name != nil {
+			local = spec.Name.Name
+		}
+		if local == "_" || local == "." {
+			continue
+		}
+		imports[local] = name
+	}
+	return imports
+}
+
+func (s Signature) String() string {
+	results := strings.Join(s.Results, ", ")
+	if len(s.Results) > 1 {
+		results = "(" + results + ")"
+	}
+	return strings.TrimSpace("func(" + strings.Join(s.Params, ", ") + ") " + results)
+}
+
+// The scores of a function that matches a query less than exactly
+const (
+	//of a parameter or result that the function takes or returns as any or
+	//as a type parameter that the query's type satisfies
+	Generalised = 0.5
+	//the factor applied if the parameters or results match only in a
+	//different order
+	Reordered = 0.75
+)
+
+// Match scores how well a function with signature f answers the query q:
+// 1 if every parameter and result is the same and in the same order, less if
+// some are more general or they are in a different order, and 0 if f
+// doesn't match at all. The number of parameters and results must be the same.
+func Match(q, f Signature) float64 {
+	if len(q.Params) != len(f.Params) || len(q.Results) != len(f.Results) {
+		return 0
+	}
+	qs := append(append([]string(nil), q.Params...), q.Results...)
+	n := len(qs)
+	if n == 0 {
+		return 1
+	}
+	if sum, ok := f.inOrder(qs); ok {
+		return sum / float64(n)
+	}
+	//parameters may only pair with parameters and results with results
+	params, b, ok := f.anyOrder(q.Params, f.Params, bindings{})
+	if !ok {
+		return 0
+	}
+	results, _, ok := f.anyOrder(q.Results, f.Results, b)
+	if !ok {
+		return 0
+	}
+	return (params + results) / float64(n) * Reordered
+}
+
+// bindings map the number of each type parameter of a function to the type of
+// the query it was matched with
+type bindings map[int]string
+
+// with returns a copy of b that also binds type parameter k to t
+func (b bindings) with(k int, t string) bindings {
+	nb := make(bindings, len(b)+1)
+	for i, bound := range b {
+		nb[i] = bound
+	}
+	nb[k] = t
+	return nb
+}
+
+// inOrder sums the scores of the types qs of a query, its parameters then its
+// results, matched against those of f in the same order
+func (f Signature) inOrder(qs []string) (float64, bool) {
+	fs := append(append([]string(nil), f.Params...), f.Results...)
+	sum, b := 0.0, bindings{}
+	for i := range qs {
+		s, nb := f.typeScore(qs[i], fs[i], b)
+		if s == 0 {
+			return 0, false
+		}
+		sum, b = sum+s, nb
+	}
+	return sum, true
+}
+
+// anyOrder pairs each of the types q of a query with one of the types fs of
+// f, exact matches first, so a more general type isn't used up by a query
+// type another one matches exactly. It returns the sum of the scores of the
+// pairs and the bindings they made, and false if some type can't be paired.
+func (f Signature) anyOrder(q, fs []string, b bindings) (float64, bindings, bool) {
+	paired := make([]bool, len(q))
+	used := make([]bool, len(fs))
+	sum := 0.0
+	for _, exact := range []bool{true, false} {
+		for i := range q {
+			if paired[i] {
+				continue
+			}
+			for j := range fs {
+				if used[j] {
+					continue
+				}
+				s, nb := f.typeScore(q[i], fs[j], b)
+				if s == 1 || (!exact && s > 0) {
+					paired[i], used[j] = true, true
+					sum, b = sum+s, nb
+					break
+				}
+			}
+		}
+	}
+	for _, ok := range paired {
+		if !ok {
+			return 0, nil, false
+		}
+	}
+	return sum, b, true
+}
+
+// typeScore scores the type t of one of f's parameters or results as a match
+// for the type q of a query, given the types its type parameters are already
+// bound to, and returns the bindings with any that the match makes
+func (f Signature) typeScore(q, t string, b bindings) (float64, bindings) {
+	switch {
+	case q == t:
+		return 1, b
+	case t == "any":
+		return Generalised, b
+	case strings.Contains(t, "$"):
+		if nb, ok := f.match(t, q, b); ok {
+			return Generalised, nb
+		}
+	}
+	return 0, nil
+}
+
+// match reports whether the query type q is an instance of t, a type written
+// with f's type parameters, and returns b with the bindings that makes. Each
+// type parameter takes a whole type of q that its constraint allows, and the
+// same one everywhere.
+func (f Signature) match(t, q string, b bindings) (bindings, bool) {
+	i := strings.IndexByte(t, '$')
+	if i < 0 {
+		return b, t == q
+	}
+	if !strings.HasPrefix(q, t[:i]) {
+		return nil, false
+	}
+	j := i + 1
+	for j < len(t) && '0' <= t[j] && t[j] <= '9' {
+		j++
+	}
+	k, _ := strconv.Atoi(t[i+1 : j])
+	t, q = t[j:], q[i:]
+	if bound, ok := b[k]; ok {
+		if !strings.HasPrefix(q, bound) {
+			return nil, false
+		}
+		return f.match(t, q[len(bound):], b)
+	}
+	for _, end := range typeEnds(q) {
+		nb, ok := f.bind(k, q[:end], b)
+		if !ok {
+			continue
+		}
+		if nb, ok = f.match(t, q[end:], nb); ok {
+			return nb, true
+		}
+	}
+	return nil, false
+}
+
+// bind binds type parameter k to the type q if its constraint allows it
+func (f Signature) bind(k int, q string, b bindings) (bindings, bool) {
+	b = b.with(k, q)
+	if k >= len(f.TypeParams) {
+		return b, true
+	}
+	constraint := f.TypeParams[k]
+	if set, ok := typeSets[constraint]; ok {
+		constraint = set
+	}
+	switch constraint {
+	case "", "any":
+		return b, true
+	case "comparable":
+		return b, !strings.HasPrefix(q, "[]") && !strings.HasPrefix(q, "map[") && !strings.HasPrefix(q, "func(")
+	}
+	terms := strings.Split(constraint, "|")
+	if len(terms) == 1 && !strings.HasPrefix(constraint, "~") && (!predeclared[constraint] || constraint == "error") {
+		//an interface whose type set isn't known
+		return b, true
+	}
+	for _, term := range terms {
+		//without type information ~T only allows T itself
+		term = strings.TrimPrefix(strings.TrimSpace(term), "~")
+		if nb, ok := f.match(term, q, b); ok {
+			return nb, true
+		}
+	}
+	return nil, false
+}
+
+// typeEnds returns the lengths of the prefixes of q that could be a whole
+// type, ending where a type may be followed by more of an enclosing one
+func typeEnds(q string) []int {
+	var ends []int
+	depth := 0
+	for i := 0; i < len(q); i++ {
+		switch q[i] {
+		case '(', '[', '{':
+			depth++
+			continue
+		case ')', ']', '}':
+			if depth == 0 {
+				return append(ends, i)
+			}
+			depth--
+			continue
+		}
+		if depth == 0 && i > 0 && (q[i] == ',' || q[i] == ' ') {
+			ends = append(ends, i)
+		}
+	}
+	return append(ends, len(q))
+}
+
+const (
+	signed   = "~int | ~int8 | ~int16 | ~int32 | ~int64"
+	unsigned = "~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 | ~uintptr"
+	float    = "~float32 | ~float64"
+)
+
+// typeSets spell out the type sets of well known constraints
+var typeSets = map[string]string{
+	"cmp.Ordered":          signed + " | " + unsigned + " | " + float + " | ~string",
+	"constraints.Ordered":  signed + " | " + unsigned + " | " + float + " | ~string",
+	"constraints.Integer":  signed + " | " + unsigned,
+	"constraints.Signed":   signed,
+	"constraints.Unsigned": unsigned,
+	"constraints.Float":    float,
+	"constraints.Complex":  "~complex64 | ~complex128",
+}
+
+type normaliser struct {
+	pack    string
+	imports map[string]string
+	tparams map[string]string
+	//where to record the constraints of the type parameters, if anywhere
+	constraints *[]string
+	pending     []pendingConstraint
+	declared    int //the number of type parameters declared so far
+}
+
+// declare numbers the type parameter named by e, which is constrained by
+// constraint if that is known. Every type parameter takes a number, so the
+// constraints stay in step with them.
+func (n *normaliser) declare(e ast.Expr, constraint ast.Expr) {
+	k := n.declared
+	n.declared++
+	if id, ok := e.(*ast.Ident); ok && id.Name != "_" {
+		n.tparams[id.Name] = "$" + strconv.Itoa(k)
+	}
+	if n.constraints != nil {
+		*n.constraints = append(*n.constraints, "")
+	}
+	if constraint != nil {
+		//constraints are normalised once every type parameter is declared,
+		//as they may refer to each other
+		n.pending = append(n.pending, pendingConstraint{k, constraint})
+	}
+}
+
+// A pendingConstraint is the constraint of type parameter k, normalised once
+// all of them are declared
+type pendingConstraint struct {
+	k    int
+	expr ast.Expr
+}
+
+func (n *normaliser) signature(ft *ast.FuncType, sig *Signature) {
+	if ft.TypeParams != nil {
+		for _, field := range ft.TypeParams.List {
+			for _, name := range field.Names {
+				n.declare(name, field.Type)
+			}
+		}
+	}
+	if n.constraints != nil {
+		for _, p := range n.pending {
+			(*n.constraints)[p.k] = n.typ(p.expr)
+		}
+	}
+	sig.Params, sig.Results = n.fields(ft.Params), n.fields(ft.Results)
+}
+
+// fields returns the types of a parameter or result list, once per name
+func (n *normaliser) fields(list *ast.FieldList) []string {
+	if list == nil {
+		return nil
+	}
+	var types []string
+	for _, field := range list.List {
+		t := n.typ(field.Type)
+		if len(field.Names) == 0 {
+			types = append(types, t)
+		}
+		for range field.Names {
+			types = append(types, t)
+		}
+	}
+	return types
+}
+
+var predeclared = map[string]bool{
+	"bool": true, "complex64": true, "complex128": true, "error": true,
+	"float32": true, "float64": true, "int": true, "int8": true,
+	"int16": true, "int32": true, "int64": true, "string": true,
+	"uint": true, "uint8": true, "uint16": true, "uint32": true,
+	"uint64": true, "uintptr": true, "any": true, "comparable": true,
+}
+
+// typ prints a type expression in normal form
+func (n *normaliser) typ(e ast.Expr) string {
+	var b bytes.Buffer
+	printer.Fprint(&b, token.NewFileSet(), n.rewrite(e))
+	return b.String()
+}
+
+// rewrite returns a copy of the type expression e in normal form
+func (n *normaliser) rewrite(e ast.Expr) ast.Expr {
+	switch x := e.(type) {
+	case *ast.Ident:
+		switch name := x.Name; {
+		case name == "byte":
+			return ast.NewIdent("uint8")
+		case name == "rune":
+			return ast.NewIdent("int32")
+		case n.tparams[name] != "":
+			return ast.NewIdent(n.tparams[name])
+		case predeclared[name] || n.pack == "":
+			return ast.NewIdent(name)
+		default:
+			return &ast.SelectorExpr{X: ast.NewIdent(n.pack), Sel: ast.NewIdent(name)}
+		}
+	case *ast.SelectorExpr:
+		if pkg, ok := x.X.(*ast.Ident); ok {
+			if name, ok := n.imports[pkg.Name]; ok {
+				return &ast.SelectorExpr{X: ast.NewIdent(name), Sel: ast.NewIdent(x.Sel.Name)}
+			}
+		}
+		return x
+	case *ast.ParenExpr:
+		return n.rewrite(x.X)
+	case *ast.StarExpr:
+		return &ast.StarExpr{X: n.rewrite(x.X)}
+	case *ast.Ellipsis:
+		return &ast.ArrayType{Elt: n.rewrite(x.Elt)}
+	case *ast.ArrayType:
+		return &ast.ArrayType{Len: x.Len, Elt: n.rewrite(x.Elt)}
+	case *ast.MapType:
+		return &ast.MapType{Key: n.rewrite(x.Key), Value: n.rewrite(x.Value)}
+	case *ast.ChanType:
+		return &ast.ChanType{Dir: x.Dir, Value: n.rewrite(x.Value)}
+	case *ast.FuncType:
+		//parameter names are dropped, as for the function itself
+		return &ast.FuncType{Params: n.fieldList(x.Params), Results: n.fieldList(x.Results)}
+	case *ast.InterfaceType:
+		if x.Methods == nil || len(x.Methods.List) == 0 {
+			return ast.NewIdent("any")
+		}
+		//a constraint written as interface{ ~int | ~int64 }
+		if elem := x.Methods.List[0]; len(x.Methods.List) == 1 && len(elem.Names) == 0 {
+			return n.rewrite(elem.Type)
+		}
+		return x
+	case *ast.BinaryExpr:
+		//a union of the terms of a constraint
+		return &ast.BinaryExpr{X: n.rewrite(x.X), Op: x.Op, Y: n.rewrite(x.Y)}
+	case *ast.UnaryExpr:
+		//the ~ of a constraint's term
+		return &ast.UnaryExpr{Op: x.Op, X: n.rewrite(x.X)}
+	case *ast.IndexExpr:
+		return &ast.IndexExpr{X: n.rewrite(x.X), Index: n.rewrite(x.Index)}
+	case *ast.IndexListExpr:
+		indices := make([]ast.Expr, len(x.Indices))
+		for i, index := range x.Indices {
+			indices[i] = n.rewrite(index)
+		}
+		return &ast.IndexListExpr{X: n.rewrite(x.X), Indices: indices}
+	}
+	return e
+}
+
+// fieldList rewrites the types of a parameter or result list of a func type,
+// one unnamed field per type
+func (n *normaliser) fieldList(list *ast.FieldList) *ast.FieldList {
+	if list == nil {
+		return nil
+	}
+	out := &ast.FieldList{}
+	for _, field := range list.List {
+		t := n.rewrite(field.Type)
+		count := len(field.Names)
+		if count == 0 {
+			count = 1
+		}
+		for i := 0; i < count; i++ {
+			out.List = append(out.List, &ast.Field{Type: t})
+		}
+	}
+	return out
+}
@@ -0,0 +1,78 @@
+package shape
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"testing"
+)
+
+const src = `package p
+
+import (
+	"io"
+	"golang.org/x/exp/constraints"
+)
+
+func Sum[T ~int | ~int64](xs []T) T
+func Max[T constraints.Ordered](a, b T) T
+func Index[S ~[]E, E comparable](s S, v E) int
+func Keys[K comparable, V any](m map[K]V) []K
+func Map[T, U any](xs []T, f func(T) U) []U
+func Dump(v interface{})
+func ReadAll(r io.Reader) ([]byte, error)
+func Swap(n int, s string) error
+`
+
+func TestMatch(t *testing.T) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "p.go", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	imports := Imports(f)
+	funcs := make(map[string]Signature)
+	for _, decl := range f.Decls {
+		if fd, ok := decl.(*ast.FuncDecl); ok {
+			funcs[fd.Name.Name] = Of(fd, "p", imports)
+		}
+	}
+
+	tests := []struct {
+		fn, query string
+		want      float64
+	}{
+		{"ReadAll", "func(io.Reader) ([]byte, error)", 1},
+		{"ReadAll", "func(io.Reader) ([]uint8, error)", 1},
+		{"ReadAll", "func(io.Writer) ([]byte, error)", 0},
+		{"Swap", "func(int, string) error", 1},
+		{"Swap", "func(string, int) error", Reordered},
+		{"Dump", "func(int)", Generalised},
+		{"Sum", "func([]int) int", Generalised},
+		{"Sum", "func([]int64) int64", Generalised},
+		//outside the type set
+		{"Sum", "func([]string) string", 0},
+		//T bound to one type throughout
+		{"Sum", "func([]int) int64", 0},
+		{"Max", "func(int, int) int", Generalised},
+		{"Max", "func(string, string) string", Generalised},
+		{"Max", "func(int, string) int", 0},
+		{"Max", "func([]int, []int) []int", 0},
+		{"Index", "func([]string, string) int", (2*Generalised + 1) / 3},
+		{"Index", "func([]string, int) int", 0},
+		{"Index", "func([][]int, []int) int", 0},
+		{"Keys", "func(map[string]int) []string", Generalised},
+		{"Keys", "func(map[string]int) []int", 0},
+		{"Map", "func([]int, func(int) string) []string", Generalised},
+		{"Map", "func([]int, func(int) string) []int", 0},
+	}
+	for _, tt := range tests {
+		q, err := Parse(tt.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := Match(q, funcs[tt.fn]); got != tt.want {
+			t.Errorf("Match(%s, %s %v) = %v, want %v", tt.query, tt.fn, funcs[tt.fn], got, tt.want)
+		}
+	}
+}
@@ -6,6 +6,11 @@ function TaskCtrl($scope, $http) {
   $scope.working = false;
   $scope.results = [];
   $scope.lastquery = '';
+  $scope.total = 0;
+  $scope.nextCursor = '';
+  $scope.didYouMean = '';
+  $scope.suggestions = [];
+  $scope.production = false;
 
   var logError = function(data, status) {
     console.log('code '+status+': '+data);
@@ -28,16 +33,48 @@ function TaskCtrl($scope, $http) {
 
   $scope.addTodo = function() {
     $scope.working = true;
-    $http.post('/search/', {Query: $scope.todoText}).
+    $http.post('/search/', {Query: $scope.todoText, Production: $scope.production}).
       error(logError).
       success(function(data) {
         $scope.results = data.Results;
+        $scope.total = data.Total;
+        $scope.nextCursor = data.NextCursor;
+        $scope.didYouMean = data.DidYouMean;
         $scope.lastquery = $scope.todoText
         $scope.working = false;
         $scope.todoText = '';
       });
   };
 
+  $scope.suggest = function() {
+    var words = ($scope.todoText || '').split(' ');
+    var last = words[words.length-1];
+    if(last.length < 2) {
+      $scope.suggestions = [];
+      return;
+    }
+    $http.get('/suggest', {params: {prefix: last}}).
+      error(logError).
+      success(function(data) {
+        words.pop();
+        var before = words.length > 0 ? words.join(' ') + ' ' : '';
+        $scope.suggestions = data.Suggestions.map(function(s) {
+          return {Text: before + s.Term, Kind: s.Kind};
+        });
+      });
+  };
+
+  $scope.more = function() {
+    $scope.working = true;
+    $http.post('/search/', {Query: $scope.lastquery, Cursor: $scope.nextCursor, Production: $scope.production}).
+      error(logError).
+      success(function(data) {
+        $scope.results = $scope.results.concat(data.Results);
+        $scope.nextCursor = data.NextCursor;
+        $scope.working = false;
+      });
+  };
+
   $scope.toggleDone = function(task) {
     data = {ID: task.ID, Title: task.Title, Done: !task.Done}
     $http.put('//'+task.ID, data).
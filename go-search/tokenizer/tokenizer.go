@@ -0,0 +1,78 @@
+// Package tokenizer splits Go identifiers into the words they are indexed and
+// searched under. The parsers and the query parser both use it, so a query
+// word always matches the way an identifier was indexed.
+package tokenizer
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Split breaks an identifier into its words, keeping their case. A new word
+// starts at an upper case letter that follows a lower case letter or digit,
+// and at the last letter of a run of upper case letters that is followed by
+// lower case, so acronyms stay whole. Digits belong to the word before them.
+// Underscores and other punctuation separate words and are dropped.
+//
+//	HTTPServer    HTTP Server
+//	ParseURL2     Parse URL2
+//	MD5Sum        MD5 Sum
+//	URLsFor       URLs For
+//	read_all      read all
+func Split(ident string) []string {
+	var words []string
+	runes := []rune(ident)
+	start := -1
+	for i, r := range runes {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			if start >= 0 {
+				words = append(words, string(runes[start:i]))
+				start = -1
+			}
+			continue
+		}
+		if start < 0 {
+			start = i
+			continue
+		}
+		if unicode.IsUpper(r) {
+			prev := runes[i-1]
+			acronymEnd := unicode.IsUpper(prev) && i+1 < len(runes) && unicode.IsLower(runes[i+1]) &&
+				!pluralAcronym(runes[i+1:])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || acronymEnd {
+				words = append(words, string(runes[start:i]))
+				start = i
+			}
+		}
+	}
+	if start >= 0 {
+		words = append(words, string(runes[start:]))
+	}
+	return words
+}
+
+// pluralAcronym reports whether the rest of a word after an acronym is just
+// the "s" of a plural, as in URLs or IDs
+func pluralAcronym(rest []rune) bool {
+	return rest[0] == 's' && (len(rest) == 1 || !unicode.IsLower(rest[1]))
+}
+
+// Words returns the lower case words of an identifier, followed by the whole
+// identifier with its underscores removed when it has more than one word, and
+// then by the identifier as written, in lower case, if that is different
+// again. So "read" and "readall" find ReadAll, and "read_all" finds read_all
+// as well as "readall" does.
+func Words(ident string) []string {
+	split := Split(ident)
+	words := make([]string, 0, len(split)+2)
+	for _, w := range split {
+		words = append(words, strings.ToLower(w))
+	}
+	if len(words) > 1 {
+		words = append(words, strings.Join(words, ""))
+	}
+	if whole := strings.ToLower(ident); len(words) > 0 && whole != words[len(words)-1] {
+		words = append(words, whole)
+	}
+	return words
+}
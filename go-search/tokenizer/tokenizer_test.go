@@ -0,0 +1,55 @@
+package tokenizer
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		ident string
+		want  []string
+	}{
+		{"HTTPServer", []string{"HTTP", "Server"}},
+		{"ParseURL2", []string{"Parse", "URL2"}},
+		{"MD5Sum", []string{"MD5", "Sum"}},
+		{"URLsFor", []string{"URLs", "For"}},
+		{"read_all", []string{"read", "all"}},
+		{"O_RDONLY", []string{"O", "RDONLY"}},
+		{"ReadAll", []string{"Read", "All"}},
+		{"X", []string{"X"}},
+		{"x", []string{"x"}},
+		{"NewX", []string{"New", "X"}},
+		{"XMLHttpRequest", []string{"XML", "Http", "Request"}},
+		{"_", nil},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		if got := Split(tt.ident); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Split(%q) = %q, want %q", tt.ident, got, tt.want)
+		}
+	}
+}
+
+func TestWords(t *testing.T) {
+	tests := []struct {
+		ident string
+		want  []string
+	}{
+		{"HTTPServer", []string{"http", "server", "httpserver"}},
+		{"ParseURL2", []string{"parse", "url2", "parseurl2"}},
+		{"MD5Sum", []string{"md5", "sum", "md5sum"}},
+		{"read_all", []string{"read", "all", "readall", "read_all"}},
+		{"O_RDONLY", []string{"o", "rdonly", "ordonly", "o_rdonly"}},
+		{"ReadAll", []string{"read", "all", "readall"}},
+		{"X", []string{"x"}},
+		{"NewX", []string{"new", "x", "newx"}},
+		{"io", []string{"io"}},
+		{"_", []string{}},
+	}
+	for _, tt := range tests {
+		if got := Words(tt.ident); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Words(%q) = %q, want %q", tt.ident, got, tt.want)
+		}
+	}
+}